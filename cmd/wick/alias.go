@@ -0,0 +1,234 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const aliasesSection = "aliases"
+
+// splitCommand splits an alias command into arguments the way a shell
+// would, honoring single and double quotes and backslash escapes.
+func splitCommand(command string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+	for _, c := range command {
+		switch {
+		case escaped:
+			current.WriteRune(c)
+			escaped = false
+		case c == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			inArg = true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteRune(c)
+			inArg = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %q", command)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// commandIndex returns the index of the command in args, skipping global
+// flags and their values, or -1 if there is none.
+func commandIndex(args []string) int {
+	flags := map[string]*kingpin.FlagModel{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		flags["--"+flag.Name] = flag
+		if flag.Short != 0 {
+			flags["-"+string(flag.Short)] = flag
+		}
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return i
+		}
+		if arg == "--" {
+			return -1
+		}
+		if flag, ok := flags[arg]; ok && !flag.IsBoolFlag() {
+			// The value is the next argument.
+			i++
+		}
+	}
+	return -1
+}
+
+// expandAlias replaces an alias given as command in args with the command
+// it stands for. Arguments NAME=VALUE given to the alias fill the
+// placeholders ending with NAME= in its command, other arguments are
+// appended to it.
+func expandAlias(args []string) ([]string, error) {
+	index := commandIndex(args)
+	if index < 0 {
+		return args, nil
+	}
+	name := args[index]
+	for _, command := range kingpin.CommandLine.Model().Commands {
+		if command.Name == name {
+			return args, nil
+		}
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	command, ok := sections[aliasesSection][name]
+	if !ok {
+		return args, nil
+	}
+	aliasArgs, err := splitCommand(command)
+	if err != nil {
+		return nil, fmt.Errorf("alias %s: %w", name, err)
+	}
+
+	var extra []string
+	for _, arg := range args[index+1:] {
+		filled := false
+		if i := strings.Index(arg, "="); i > 0 && !strings.HasPrefix(arg, "-") {
+			placeholder := arg[:i+1]
+			for j, aliasArg := range aliasArgs {
+				if strings.HasSuffix(aliasArg, placeholder) {
+					aliasArgs[j] += arg[i+1:]
+					filled = true
+				}
+			}
+		}
+		if !filled {
+			extra = append(extra, arg)
+		}
+	}
+
+	expanded := append([]string{}, args[:index]...)
+	expanded = append(expanded, aliasArgs...)
+	return append(expanded, extra...), nil
+}
+
+func aliasAdd(name string, command string) error {
+	for _, existing := range kingpin.CommandLine.Model().Commands {
+		if existing.Name == name {
+			return fmt.Errorf("%s is a wick command and can't be an alias", name)
+		}
+	}
+	args, err := splitCommand(command)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("alias %s needs a command", name)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if sections[aliasesSection] == nil {
+		sections[aliasesSection] = map[string]string{}
+	}
+	sections[aliasesSection][name] = command
+
+	return writeConfig(path, sections)
+}
+
+func aliasRemove(name string) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := sections[aliasesSection][name]; !ok {
+		return fmt.Errorf("no alias %s", name)
+	}
+	delete(sections[aliasesSection], name)
+	if len(sections[aliasesSection]) == 0 {
+		delete(sections, aliasesSection)
+	}
+
+	return writeConfig(path, sections)
+}
+
+func aliasList() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(sections[aliasesSection]))
+	for name := range sections[aliasesSection] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%-20s %s\n", name, sections[aliasesSection][name])
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+const defaultsSection = "defaults"
+
+// configPath returns the location of the wick config file, ~/.wick/config.
+func configPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".wick", "config"), nil
+}
+
+func defaultAuditLog() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "audit.log"
+	}
+	return filepath.Join(home, ".wick", "audit.log")
+}
+
+func defaultCacheDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "cache"
+	}
+	return filepath.Join(home, ".wick", "cache")
+}
+
+func defaultSchemaDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "schemas"
+	}
+	return filepath.Join(home, ".wick", "schemas")
+}
+
+// configurableFlags maps the names of global flags that can be read from the
+// environment to their environment variable. Only those flags can be given
+// defaults in the config file.
+func configurableFlags() map[string]*kingpin.FlagModel {
+	flags := map[string]*kingpin.FlagModel{}
+	for _, flag := range kingpin.CommandLine.Model().Flags {
+		if flag.Envar != "" {
+			flags[flag.Name] = flag
+		}
+	}
+	return flags
+}
+
+// readConfig parses an INI style config file into its sections. A missing
+// file is not an error.
+func readConfig(path string) (map[string]map[string]string, error) {
+	sections := map[string]map[string]string{}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return sections, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if sections[section] == nil {
+				sections[section] = map[string]string{}
+			}
+			continue
+		}
+
+		index := strings.Index(line, "=")
+		if index < 0 || section == "" {
+			return nil, fmt.Errorf("%s:%d: invalid line %q", path, lineNumber, line)
+		}
+		key := strings.TrimSpace(line[:index])
+		value := strings.TrimSpace(line[index+1:])
+		if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+			value = value[1 : len(value)-1]
+		}
+		sections[section][key] = value
+	}
+
+	return sections, scanner.Err()
+}
+
+func writeConfig(path string, sections map[string]map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(sections))
+	for name := range sections {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var builder strings.Builder
+	for i, name := range names {
+		if i > 0 {
+			builder.WriteString("\n")
+		}
+		fmt.Fprintf(&builder, "[%s]\n", name)
+
+		keys := make([]string, 0, len(sections[name]))
+		for key := range sections[name] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value := sections[name][key]
+			if strings.HasPrefix(value, `"`) || strings.HasSuffix(value, `"`) {
+				// Keep the quotes of the value, readConfig removes one pair.
+				value = `"` + value + `"`
+			}
+			fmt.Fprintf(&builder, "%s = %s\n", key, value)
+		}
+	}
+
+	return os.WriteFile(path, []byte(builder.String()), 0600)
+}
+
+// applyConfigDefaults exports the values from the [defaults] section of the
+// config file as environment variables, so they are picked up as flag
+// defaults. Flags and environment variables that are already set win.
+func applyConfigDefaults() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	flags := configurableFlags()
+	for key, value := range sections[defaultsSection] {
+		flag, ok := flags[key]
+		if !ok {
+			return fmt.Errorf("%s: unknown key %q in [%s]", path, key, defaultsSection)
+		}
+		if _, set := os.LookupEnv(flag.Envar); !set {
+			if err = os.Setenv(flag.Envar, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func configSet(key string, value string) error {
+	flag, ok := configurableFlags()[key]
+	if !ok {
+		return fmt.Errorf("unknown config key %q, see 'wick config list' for valid keys", key)
+	}
+	// Validate the value the same way the flag would.
+	if err := flag.Value.Set(value); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", key, err)
+	}
+
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	if sections[defaultsSection] == nil {
+		sections[defaultsSection] = map[string]string{}
+	}
+	sections[defaultsSection][key] = value
+
+	return writeConfig(path, sections)
+}
+
+func configList() error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	sections, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+
+	flags := configurableFlags()
+	keys := make([]string, 0, len(flags))
+	for key := range flags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value, ok := sections[defaultsSection][key]
+		if !ok {
+			value = strings.Join(flags[key].Default, ",")
+		}
+		fmt.Printf("%-20s %s\n", key, value)
+	}
+
+	return nil
+}
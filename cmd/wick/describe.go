@@ -0,0 +1,178 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// The types below describe the command line interface as JSON, so that
+// wrappers can build forms from the actual flag definitions.
+
+type flagDescription struct {
+	Name        string   `json:"name"`
+	Help        string   `json:"help"`
+	Short       string   `json:"short,omitempty"`
+	Type        string   `json:"type"`
+	Options     []string `json:"options,omitempty"`
+	Default     []string `json:"default,omitempty"`
+	Envar       string   `json:"envar,omitempty"`
+	PlaceHolder string   `json:"placeholder,omitempty"`
+	Required    bool     `json:"required"`
+	Repeatable  bool     `json:"repeatable"`
+}
+
+type argDescription struct {
+	Name       string   `json:"name"`
+	Help       string   `json:"help"`
+	Type       string   `json:"type"`
+	Options    []string `json:"options,omitempty"`
+	Default    []string `json:"default,omitempty"`
+	Required   bool     `json:"required"`
+	Repeatable bool     `json:"repeatable"`
+}
+
+type commandDescription struct {
+	Name     string                `json:"name"`
+	Help     string                `json:"help"`
+	Hidden   bool                  `json:"hidden,omitempty"`
+	Flags    []*flagDescription    `json:"flags,omitempty"`
+	Args     []*argDescription     `json:"args,omitempty"`
+	Commands []*commandDescription `json:"commands,omitempty"`
+}
+
+// describeFlags prints the command and flag tree as JSON and exits.
+func describeFlags(_ *kingpin.ParseContext) error {
+	model := kingpin.CommandLine.Model()
+	description := &commandDescription{
+		Name:     model.Name,
+		Help:     model.Help,
+		Flags:    describeFlagGroup(model.FlagGroupModel),
+		Args:     describeArgGroup(model.ArgGroupModel),
+		Commands: describeCmdGroup(model.CmdGroupModel),
+	}
+
+	jsonString, err := json.MarshalIndent(description, "", "    ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(jsonString))
+	os.Exit(0)
+	return nil
+}
+
+func describeFlagGroup(group *kingpin.FlagGroupModel) []*flagDescription {
+	var flags []*flagDescription
+	for _, flag := range group.Flags {
+		if flag.Hidden {
+			continue
+		}
+		valueType, options := describeValue(flag.Value)
+		description := &flagDescription{
+			Name:        flag.Name,
+			Help:        flag.Help,
+			Type:        valueType,
+			Options:     options,
+			Default:     flag.Default,
+			Envar:       flag.Envar,
+			PlaceHolder: flag.PlaceHolder,
+			Required:    flag.Required,
+			Repeatable:  isRepeatable(flag.Value),
+		}
+		if flag.Short != 0 {
+			description.Short = string(flag.Short)
+		}
+		flags = append(flags, description)
+	}
+	return flags
+}
+
+func describeArgGroup(group *kingpin.ArgGroupModel) []*argDescription {
+	var args []*argDescription
+	for _, arg := range group.Args {
+		valueType, options := describeValue(arg.Value)
+		args = append(args, &argDescription{
+			Name:       arg.Name,
+			Help:       arg.Help,
+			Type:       valueType,
+			Options:    options,
+			Default:    arg.Default,
+			Required:   arg.Required,
+			Repeatable: isRepeatable(arg.Value),
+		})
+	}
+	return args
+}
+
+func describeCmdGroup(group *kingpin.CmdGroupModel) []*commandDescription {
+	var commands []*commandDescription
+	for _, cmd := range group.Commands {
+		commands = append(commands, &commandDescription{
+			Name:     cmd.Name,
+			Help:     cmd.Help,
+			Hidden:   cmd.Hidden,
+			Flags:    describeFlagGroup(cmd.FlagGroupModel),
+			Args:     describeArgGroup(cmd.ArgGroupModel),
+			Commands: describeCmdGroup(cmd.CmdGroupModel),
+		})
+	}
+	return commands
+}
+
+// describeValue returns a type name for a kingpin value, plus the allowed
+// options for enums. kingpin does not export either, so they are derived
+// from the value's concrete type.
+func describeValue(value kingpin.Value) (string, []string) {
+	typeName := strings.TrimSuffix(strings.TrimPrefix(fmt.Sprintf("%T", value), "*kingpin."), "Value")
+	switch typeName {
+	case "accumulator":
+		return "strings", nil
+	case "fileStat":
+		return "file", nil
+	case "stringMap":
+		return "map", nil
+	case "enum":
+		var options []string
+		field := reflect.ValueOf(value).Elem().FieldByName("options")
+		if field.IsValid() && field.Kind() == reflect.Slice {
+			for i := 0; i < field.Len(); i++ {
+				options = append(options, field.Index(i).String())
+			}
+		}
+		return "enum", options
+	}
+	return typeName, nil
+}
+
+func isRepeatable(value kingpin.Value) bool {
+	cumulative, ok := value.(interface{ IsCumulative() bool })
+	return ok && cumulative.IsCumulative()
+}
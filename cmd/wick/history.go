@@ -0,0 +1,289 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	wick "github.com/s-things/wick/wamp"
+)
+
+// secretFlags are the flags whose values are left out of the history.
+var secretFlags = []string{"secret", "ticket", "private-key", "seal-key", "header", "proxy"}
+
+// sealedPlaceholder replaces the values of sealed kwargs in the history.
+const sealedPlaceholder = "<sealed>"
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "history"
+	}
+	return filepath.Join(home, ".wick", "history")
+}
+
+// redactCommand removes secretFlags and their values from args, and
+// replaces the values of the sealed kwargs with sealedPlaceholder. Running
+// the command again picks the secrets up from the environment or config
+// file, and unseals the kwargs from the history entry.
+func redactCommand(args []string, sealed []string) []string {
+	flags := map[string]bool{}
+	for _, name := range secretFlags {
+		flags[name] = false
+	}
+	return redactSealedKwargs(removeFlags(args, flags), sealed)
+}
+
+// redactSealedKwargs replaces the values of the sealed kwargs given with -k
+// or --kwarg in args with sealedPlaceholder.
+func redactSealedKwargs(args []string, sealed []string) []string {
+	names := map[string]bool{}
+	for _, name := range sealed {
+		names[name] = true
+	}
+	redact := func(kwarg string) string {
+		if parts := strings.SplitN(kwarg, "=", 2); len(parts) == 2 && names[parts[0]] {
+			return parts[0] + "=" + sealedPlaceholder
+		}
+		return kwarg
+	}
+
+	redacted := make([]string, len(args))
+	copy(redacted, args)
+	for i := 0; i < len(redacted); i++ {
+		arg := redacted[i]
+		switch {
+		case arg == "-k" || arg == "--kwarg":
+			if i+1 < len(redacted) {
+				i++
+				redacted[i] = redact(redacted[i])
+			}
+		case strings.HasPrefix(arg, "--kwarg="):
+			redacted[i] = "--kwarg=" + redact(strings.TrimPrefix(arg, "--kwarg="))
+		case strings.HasPrefix(arg, "-k"):
+			redacted[i] = "-k" + redact(strings.TrimPrefix(arg, "-k"))
+		}
+	}
+	return redacted
+}
+
+// unsealCommand puts the values of the sealed kwargs, decrypted from kwargs
+// of the history entry, back in place of sealedPlaceholder in command.
+func unsealCommand(command []string, kwargs wamp.Dict) ([]string, error) {
+	unsealed := make([]string, len(command))
+	copy(unsealed, command)
+	for i, arg := range unsealed {
+		if !strings.HasSuffix(arg, "="+sealedPlaceholder) {
+			continue
+		}
+		kwarg := strings.TrimSuffix(arg, "="+sealedPlaceholder)
+		prefix := ""
+		if strings.HasPrefix(kwarg, "--kwarg=") {
+			prefix = "--kwarg="
+		} else if strings.HasPrefix(kwarg, "-k") {
+			prefix = "-k"
+		}
+		name := strings.TrimPrefix(kwarg, prefix)
+
+		if *sealKey == "" {
+			return nil, errors.New("the command has sealed kwargs, set --seal-key or WICK_SEAL_KEY to run it again")
+		}
+		if err := wick.EnableSealing([]string{name}, *sealKey); err != nil {
+			return nil, err
+		}
+		sealed, ok := kwargs[name]
+		if !ok {
+			return nil, fmt.Errorf("no sealed value of kwarg '%s' in the history entry", name)
+		}
+		value, err := wick.UnsealValue(sealed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unseal kwarg '%s': %w", name, err)
+		}
+		text, ok := value.(string)
+		if !ok {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			text = string(encoded)
+		}
+		unsealed[i] = prefix + name + "=" + text
+	}
+	return unsealed, nil
+}
+
+// removeFlags removes the flags and their values from args. The value of a
+// flag that isn't boolean may be the next argument.
+func removeFlags(args []string, flags map[string]bool) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		remove := false
+		for name, boolean := range flags {
+			if arg == "--"+name {
+				if !boolean {
+					// The value is the next argument.
+					i++
+				}
+				remove = true
+			} else if strings.HasPrefix(arg, "--"+name+"=") || (boolean && arg == "--no-"+name) {
+				remove = true
+			}
+		}
+		if !remove {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// protectionArgs returns the protection flags set on this invocation, so
+// that rerun applies them to the command it runs, and their names, with
+// whether they are boolean.
+func protectionArgs() ([]string, map[string]bool) {
+	var args []string
+	flags := map[string]bool{}
+	if *protected {
+		args = append(args, "--protected")
+		flags["protected"] = true
+	}
+	if *protectedTopics != "" {
+		args = append(args, "--protected-topics="+*protectedTopics)
+		flags["protected-topics"] = false
+	}
+	if *blockedProcedures != "" {
+		args = append(args, "--blocked-procedures="+*blockedProcedures)
+		flags["blocked-procedures"] = false
+	}
+	if *yes {
+		args = append(args, "--yes")
+		flags["yes"] = true
+	}
+	return args, flags
+}
+
+// quoteCommand joins args so they can be pasted into a shell.
+func quoteCommand(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// printHistory prints the last limit entries of the history whose
+// operation, URI, payload or command match the regular expression grep.
+func printHistory(grep string, limit int) error {
+	pattern, err := regexp.Compile(grep)
+	if err != nil {
+		return fmt.Errorf("invalid --grep: %w", err)
+	}
+	entries, err := wick.ReadHistory(defaultHistoryPath())
+	if err != nil {
+		return err
+	}
+	entries = wick.LastHistoryEntries(entries, *historySize)
+
+	var matched []wick.HistoryEntry
+	for _, entry := range entries {
+		command := quoteCommand(entry.Command)
+		payload := fmt.Sprintf("%v %v", entry.Args, entry.Kwargs)
+		if pattern.MatchString(entry.Operation) || pattern.MatchString(entry.URI) ||
+			pattern.MatchString(payload) || pattern.MatchString(command) {
+			matched = append(matched, entry)
+		}
+	}
+	if limit > 0 && len(matched) > limit {
+		matched = matched[len(matched)-limit:]
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTIME\tOPERATION\tURI\tSTATUS\tCOMMAND")
+	for _, entry := range matched {
+		status := entry.Status
+		if entry.Error != "" {
+			status += " (" + entry.Error + ")"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\twick %s\n", entry.ID, entry.Time, entry.Operation, entry.URI, status,
+			quoteCommand(entry.Command))
+	}
+	return w.Flush()
+}
+
+// rerun runs the command of the history entry with id again and exits with
+// its exit code. Protection flags given to rerun, e.g. --protected, replace
+// those of the history entry.
+func rerun(id int) error {
+	entries, err := wick.ReadHistory(defaultHistoryPath())
+	if err != nil {
+		return err
+	}
+	var command []string
+	var kwargs wamp.Dict
+	for _, entry := range wick.LastHistoryEntries(entries, *historySize) {
+		if entry.ID == id {
+			command, kwargs = entry.Command, entry.Kwargs
+		}
+	}
+	if command == nil {
+		return fmt.Errorf("no history entry %d, see 'wick history'", id)
+	}
+	protection, protectionFlags := protectionArgs()
+	command = append(protection, removeFlags(command, protectionFlags)...)
+	// Show the command as stored, without the values of sealed kwargs.
+	fmt.Fprintf(os.Stderr, "wick %s\n", quoteCommand(command))
+	if command, err = unsealCommand(command, kwargs); err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(executable, command...)
+	if *sealKey != "" {
+		// The seal key is left out of the history, the command needs it.
+		cmd.Env = append(os.Environ(), "WICK_SEAL_KEY="+*sealKey)
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		os.Exit(exitErr.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		sealed   []string
+		expected []string
+	}{
+		{"secret flags", []string{"--ticket", "t0k3n", "--seal-key=abcd", "call", "foo"}, nil,
+			[]string{"call", "foo"}},
+		{"sealed kwarg", []string{"--seal", "ssn", "publish", "people", "-k", "ssn=123-45", "-k", "name=bob"},
+			[]string{"ssn"},
+			[]string{"--seal", "ssn", "publish", "people", "-k", "ssn=<sealed>", "-k", "name=bob"}},
+		{"sealed kwarg forms", []string{"call", "foo", "-kssn=1", "--kwarg=ssn=2", "--kwarg", "ssn=3"},
+			[]string{"ssn"},
+			[]string{"call", "foo", "-kssn=<sealed>", "--kwarg=ssn=<sealed>", "--kwarg", "ssn=<sealed>"}},
+		{"nothing sealed", []string{"call", "foo", "-k", "ssn=123-45"}, nil,
+			[]string{"call", "foo", "-k", "ssn=123-45"}},
+		{"value containing =", []string{"call", "foo", "-k", "token=a=b"}, []string{"token"},
+			[]string{"call", "foo", "-k", "token=<sealed>"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if redacted := redactCommand(test.args, test.sealed); !reflect.DeepEqual(redacted, test.expected) {
+				t.Errorf("got %q, expected %q", redacted, test.expected)
+			}
+		})
+	}
+}
+
+func TestUnsealCommandWithoutKey(t *testing.T) {
+	*sealKey = ""
+	if _, err := unsealCommand([]string{"call", "foo", "-k", "ssn=<sealed>"}, nil); err == nil {
+		t.Error("expected sealed kwargs to need the seal key")
+	}
+	command := []string{"call", "foo", "-k", "name=bob"}
+	if unsealed, err := unsealCommand(command, nil); err != nil || !reflect.DeepEqual(unsealed, command) {
+		t.Errorf("got %q, %v, expected the command unchanged", unsealed, err)
+	}
+}
@@ -25,6 +25,14 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
 	"github.com/gammazero/nexus/v3/client"
 	"github.com/gammazero/nexus/v3/transport/serialize"
 	"github.com/gammazero/nexus/v3/wamp"
@@ -53,35 +61,469 @@ var (
 		Envar("WICK_TICKET").String()
 	serializer = kingpin.Flag("serializer", "The serializer to use").Envar("WICK_SERIALIZER").
 			Default("json").Enum("json", "msgpack", "cbor")
+	agent = kingpin.Flag("agent", "The agent string to send in HELLO").Envar("WICK_AGENT").
+		PlaceHolder(defaultAgent()).String()
+	maxPrintBytes = kingpin.Flag("max-print-bytes", "Truncate printed payloads larger than this").
+			Default(strconv.Itoa(wick.DefaultMaxPrintBytes)).Int()
+	printFull = kingpin.Flag("full", "Print payloads in full, without truncation").Bool()
+	noColor   = kingpin.Flag("no-color", "Disable colorized output").Envar("WICK_NO_COLOR").Bool()
+	logLevel  = kingpin.Flag("log-level", "The log level to use").Envar("WICK_LOG_LEVEL").Default("info").
+			Enum("debug", "info", "warn", "error")
+	healthAddr = kingpin.Flag("health-addr", "Serve /healthz and /readyz on this address for subscribe and register").
+			PlaceHolder(":8081").Envar("WICK_HEALTH_ADDR").String()
+	label = kingpin.Flag("label", "Label added to log lines and scenario output of this process").
+		Envar("WICK_LABEL").String()
+	transform = kingpin.Flag("transform", "jq expression applied to {args, kwargs} of events and results "+
+		"before printing or teeing").String()
+	sealKwargs = kingpin.Flag("seal", "Encrypt this kwarg with NaCl secretbox when sending and decrypt it "+
+		"when receiving (repeatable)").PlaceHolder("KWARG").Strings()
+	sealKey = kingpin.Flag("seal-key", "32 byte secret key in hex used by --seal").Envar("WICK_SEAL_KEY").
+		String()
+	progressJSON = kingpin.Flag("progress-json", "Write progress events as JSON lines to stderr").Bool()
+	pprofAddr    = kingpin.Flag("pprof-addr", "Serve net/http/pprof profiles on this address").
+			PlaceHolder(":6060").Envar("WICK_PPROF_ADDR").String()
+	chaos = kingpin.Flag("chaos", "Inject faults into wick's own transport, "+
+		"e.g. 'disconnect:5%/min,latency:200ms±100ms'").String()
+	throttle = kingpin.Flag("throttle", "Limit the bandwidth of wick's transport in each direction, "+
+		"e.g. 256kbps").String()
+	jitter = kingpin.Flag("jitter", "Wait a random time in this range before each repeated call or publish and "+
+		"scenario instance join, e.g. 0..100ms").String()
+	uriPrefix = kingpin.Flag("uri-prefix", "Prefix of relative URIs, those starting with a dot").
+			PlaceHolder("com.example.app").Envar("WICK_URI_PREFIX").String()
+	metaConcurrency = kingpin.Flag("meta-concurrency", "Meta API lookups to run at once when listing "+
+		"sessions, registrations and subscriptions").Default(strconv.Itoa(wick.DefaultMetaConcurrency)).Int()
+	seed = kingpin.Flag("seed", "Seed of random template values such as randint, to reproduce a run "+
+		"(default: random)").Int64()
+	strictArgs = kingpin.Flag("strict-args", "Fail on malformed JSON and other argument values "+
+		"that would be sent as strings by mistake").Envar("WICK_STRICT_ARGS").Bool()
+	validate = kingpin.Flag("validate", "Validate call and publish payloads against JSON schemas").
+			Envar("WICK_VALIDATE").Bool()
+	schemaDir = kingpin.Flag("schema-dir", "Directory of JSON schemas named <uri>.json").
+			Default(defaultSchemaDir()).Envar("WICK_SCHEMA_DIR").String()
+	correlationID = kingpin.Flag("correlation-id", "Correlation id sent with calls and publishes (default: random UUID)").
+			Envar("WICK_CORRELATION_ID").String()
+	correlationKwarg = kingpin.Flag("correlation-kwarg", "Also send the correlation id as this kwarg").
+				Envar("WICK_CORRELATION_KWARG").String()
+	sni = kingpin.Flag("sni", "Override the TLS server name sent to the router").
+		Envar("WICK_SNI").String()
+	alpn = kingpin.Flag("alpn", "ALPN protocol to offer in the TLS handshake (repeatable)").
+		Envar("WICK_ALPN").Strings()
+	tlsCA = kingpin.Flag("tls-ca", "PEM bundle of CA certificates to verify the router against").
+		Envar("WICK_TLS_CA").ExistingFile()
+	tlsClientCert = kingpin.Flag("tls-client-cert", "PEM client certificate for mutual TLS").
+			Envar("WICK_TLS_CLIENT_CERT").ExistingFile()
+	tlsClientKey = kingpin.Flag("tls-client-key", "PEM key of the client certificate").
+			Envar("WICK_TLS_CLIENT_KEY").ExistingFile()
+	tlsInsecure = kingpin.Flag("tls-insecure", "Don't verify the router certificate").
+			Envar("WICK_TLS_INSECURE").Bool()
+	proxy = kingpin.Flag("proxy", "http:// or socks5:// proxy to connect through over websocket, "+
+		"instead of HTTP_PROXY or HTTPS_PROXY").PlaceHolder("URL").Envar("WICK_PROXY").String()
+	headers = kingpin.Flag("header", "Header to send in the websocket handshake (repeatable)").
+		PlaceHolder("\"NAME: VALUE\"").Envar("WICK_HEADER").Strings()
+	pinSHA256 = kingpin.Flag("pin-sha256", "Base64 SHA-256 of the router certificate or public key to pin (repeatable)").
+			Envar("WICK_PIN_SHA256").Strings()
+	protected = kingpin.Flag("protected", "Ask for confirmation before destructive operations").
+			Envar("WICK_PROTECTED").Bool()
+	protectedTopics = kingpin.Flag("protected-topics", "Comma separated topic patterns that need confirmation to publish to").
+			PlaceHolder("com.prod.*").Envar("WICK_PROTECTED_TOPICS").String()
+	blockedProcedures = kingpin.Flag("blocked-procedures", "Comma separated procedure patterns that need confirmation to call").
+				PlaceHolder("com.prod.*").Envar("WICK_BLOCKED_PROCEDURES").String()
+	yes          = kingpin.Flag("yes", "Skip confirmation of destructive operations").Bool()
+	auditEnabled = kingpin.Flag("audit", "Record every call, publish, subscribe and register in the audit log").
+			Envar("WICK_AUDIT").Bool()
+	historySize = kingpin.Flag("history-size", "Calls and publishes to keep in the history, 0 disables it").
+			Default(strconv.Itoa(wick.DefaultHistorySize)).Envar("WICK_HISTORY_SIZE").Int()
+	auditLog = kingpin.Flag("audit-log", "Path of the audit log").Default(defaultAuditLog()).
+			Envar("WICK_AUDIT_LOG").String()
+	keepAlive = kingpin.Flag("keepalive", "Interval between websocket pings, 0 to disable").
+			Envar("WICK_KEEPALIVE").Default("0s").Duration()
+	maxMsgSize = kingpin.Flag("max-msg-size", "Longest message in bytes the router may send over rawsocket, "+
+		"rounded up to a power of two (0 for the maximum of 16 MiB)").Envar("WICK_MAX_MSG_SIZE").Default("0").Int()
+	connectTimeout = kingpin.Flag("connect-timeout", "Give up connecting to the router after this long, 0 to wait "+
+		"as long as the OS does").Envar("WICK_CONNECT_TIMEOUT").Default("0s").Duration()
+	responseTimeout = kingpin.Flag("response-timeout", "Give up waiting for the router to answer joining, "+
+		"subscribing and registering after this long, 0 for the default of 5s").Envar("WICK_RESPONSE_TIMEOUT").
+		Default("0s").Duration()
+	helloDetails = kingpin.Flag("hello-detail", "Set an arbitrary HELLO detail (e.g. roles, resumable)").
+			PlaceHolder("KEY=VALUE").StringMap()
 
-	subscribe      = kingpin.Command("subscribe", "subscribe a topic.")
-	subscribeTopic = subscribe.Arg("topic", "Topic to subscribe to").Required().String()
+	subscribe           = kingpin.Command("subscribe", "subscribe a topic.")
+	subscribeTopics     = subscribe.Arg("topics", "Topics to subscribe to").Strings()
+	subscribeTopicsFile = subscribe.Flag("topics-file", "Also subscribe to the topics listed in this file, one per line").
+				ExistingFile()
 	subscribeMatch = subscribe.Flag("match", "pattern to use for subscribe").Default(wamp.MatchExact).
 			Enum(wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard)
 	subscribePrintDetails = subscribe.Flag("details", "print event details").Bool()
+	subscribeBuffer       = subscribe.Flag("buffer", "Buffer up to this many events for the handler "+
+		"(0 handles events as they arrive)").Default("0").Int()
+	subscribeOnOverflow = subscribe.Flag("on-overflow", "What to do when the event buffer is full").
+				Default(wick.OverflowDropOldest).Enum(wick.OverflowDropOldest, wick.OverflowBlock, wick.OverflowExit)
+	subscribeTee         = subscribe.Flag("tee", "Republish every event to this topic").String()
+	subscribeTeeTemplate = subscribe.Flag("tee-template", "Template producing the payload of teed events, "+
+		"e.g. '{{json .Kwargs}}'").String()
+	subscribeEventCall = subscribe.Flag("on-event-call", "Call this procedure with the payload of every event").
+				String()
+	subscribeOptions    = subscribe.Flag("option", "Set a SUBSCRIBE option").PlaceHolder("KEY=VALUE").StringMap()
+	subscribeTestaments = subscribe.Flag("testament", "Event the router publishes when this session ends "+
+		"(repeatable)").PlaceHolder("topic=URI,args=ARG").Strings()
+	subscribeReconnect = subscribe.Flag("reconnect", "Reconnect and subscribe again when the connection "+
+		"to the router is lost").Bool()
 
 	publish            = kingpin.Command("publish", "Publish to a topic.")
 	publishTopic       = publish.Arg("topic", "topic name").Required().String()
 	publishArgs        = publish.Arg("args", "give the arguments").Strings()
 	publishKeywordArgs = publish.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	publishOptions     = publish.Flag("option", "Set a PUBLISH option").PlaceHolder("KEY=VALUE").StringMap()
+	publishRepeat      = publish.Flag("repeat", "Publish this many times, {{i}} in the topic is the index "+
+		"of each publish").Default("1").Int()
+	publishDataCSV = publish.Flag("data-csv", "Add the args and kwargs of a row of this CSV file to each "+
+		"publish, publishing once per row unless --repeat is given").PlaceHolder("PATH").ExistingFile()
+	publishDataDir = publish.Flag("data-dir", "Add the args and kwargs of a JSON file in this directory to "+
+		"each publish, publishing once per file unless --repeat is given").PlaceHolder("DIR").ExistingDir()
+	publishTickTopic = publish.Flag("tick-topic", "Publish once per event received on this topic, "+
+		"up to --repeat times").PlaceHolder("URI").String()
+	publishTickStdin = publish.Flag("tick-stdin", "Publish once per line read from stdin, up to --repeat times").
+				Bool()
+	publishCSV = publish.Flag("csv", "Write the timestamp, duration, success, error URI and payload size of "+
+		"each publish to this CSV file").PlaceHolder("PATH").String()
+
+	register           = kingpin.Command("register", "Register a procedure.")
+	registerProcedure  = register.Arg("procedure", "procedure name").String()
+	onInvocationCmd    = register.Arg("command", "Shell command to run and return it's output").String()
+	registerProcedures = register.Flag("procedure", "Also register this procedure, running the command (repeatable)").
+				PlaceHolder("PROCEDURE=COMMAND").StringMap()
+	delay           = register.Flag("delay", "Register procedure after delay (in seconds)").Int()
+	invokeCount     = register.Flag("invoke-count", "Leave session after it's called requested times").Int()
+	registerOptions = register.Flag("option", "Set a REGISTER option").PlaceHolder("KEY=VALUE").StringMap()
+	yieldArgs       = register.Flag("yield-args", "Return this argument to callers if there is no command (repeatable)").
+			Strings()
+	yieldKwargs = register.Flag("yield-kwargs", "Return this keyword argument to callers if there is no command").
+			PlaceHolder("KEY=VALUE").StringMap()
+	forceReregister = register.Flag("force-reregister", "Take over procedures that are already registered "+
+		"(router must support force_reregister)").Bool()
+	responseDelay      = register.Flag("response-delay", "Wait this long before returning the result").Duration()
+	registerTestaments = register.Flag("testament", "Event the router publishes when this session ends "+
+		"(repeatable)").PlaceHolder("topic=URI,args=ARG").Strings()
+	registerReconnect = register.Flag("reconnect", "Reconnect and register again when the connection "+
+		"to the router is lost").Bool()
+
+	call             = kingpin.Command("call", "Call a procedure.")
+	callProcedure    = call.Arg("procedure", "Procedure to call").Required().String()
+	callArgs         = call.Arg("args", "give the arguments").Strings()
+	callKeywordArgs  = call.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	callExpectArgs   = call.Flag("expect-args", "Exit non-zero unless the result args equal this JSON list").String()
+	callExpectKwargs = call.Flag("expect-kwargs", "Exit non-zero unless the result kwargs equal this JSON object").
+				String()
+	callExpectError = call.Flag("expect-error", "Exit non-zero unless the call fails with this error URI").String()
+	callOptions     = call.Flag("option", "Set a CALL option").PlaceHolder("KEY=VALUE").StringMap()
+	callResultFile  = call.Flag("result-to-file", "Write the result to a file instead of printing it, "+
+		"appending progressive results as they arrive").PlaceHolder("PATH").String()
+	callCache = call.Flag("cache", "Serve the result of the same call made in the last DURATION from "+
+		"the local cache").PlaceHolder("DURATION").Duration()
+	callBypassCache = call.Flag("bypass-cache", "Call the router even if the result is cached, "+
+		"refreshing the cache").Bool()
+	callRepeat    = call.Flag("repeat", "Call the procedure this many times").Default("1").Int()
+	callParallel  = call.Flag("parallel", "Number of calls to make at a time with --repeat").Default("1").Int()
+	callSessions  = call.Flag("sessions", "Number of sessions to spread --parallel calls over").Default("1").Int()
+	callAggregate = call.Flag("aggregate", "Print a summary of the results of --repeat instead of each: "+
+		"count, sum:PATH, avg:PATH or distinct:PATH, PATH being a jq path such as .args[0].latency").String()
+	callDataCSV = call.Flag("data-csv", "Add the args and kwargs of a row of this CSV file to each call, "+
+		"calling once per row unless --repeat is given").PlaceHolder("PATH").ExistingFile()
+	callDataDir = call.Flag("data-dir", "Add the args and kwargs of a JSON file in this directory to each "+
+		"call, calling once per file unless --repeat is given").PlaceHolder("DIR").ExistingDir()
+	callTickTopic = call.Flag("tick-topic", "Call once per event received on this topic, up to --repeat times").
+			PlaceHolder("URI").String()
+	callTickStdin = call.Flag("tick-stdin", "Call once per line read from stdin, up to --repeat times").Bool()
+	callCSV       = call.Flag("csv", "Write the timestamp, duration, success, error URI and payload size of "+
+		"each call to this CSV file").PlaceHolder("PATH").String()
+
+	run        = kingpin.Command("run", "Run Starlark scenario scripts.")
+	runScripts = run.Arg("scripts", "Paths of the scenario scripts, run one after the other").Required().
+			ExistingFiles()
+	runInstances = run.Flag("instances", "Number of concurrent copies of the scenario to run").
+			Default("1").Int()
+	runSecretsFile = run.Flag("secrets-file", "File of 'authid secret' lines, instances authenticate as "+
+		"each in turn (needs --authmethod wampcra or ticket)").ExistingFile()
+	runParallelFiles = run.Flag("parallel-files", "Run the scripts at the same time instead of one after "+
+		"the other").Bool()
+
+	bridge           = kingpin.Command("bridge", "Bridge traffic between realms.")
+	bridgeWamp       = bridge.Command("wamp", "Forward events and calls from a realm to another realm.")
+	bridgeTopics     = bridgeWamp.Flag("topic", "Topic to forward, may be repeated").Strings()
+	bridgeProcedures = bridgeWamp.Flag("procedure", "Procedure to proxy from the destination realm, may be repeated").
+				Strings()
+	bridgeMatch = bridgeWamp.Flag("match", "pattern to use for subscribe").Default(wamp.MatchExact).
+			Enum(wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard)
+	bridgeToURL    = bridgeWamp.Flag("to-url", "WAMP URL of the destination router (default: --url)").String()
+	bridgeToRealm  = bridgeWamp.Flag("to-realm", "The destination realm to publish to").Required().String()
+	bridgeRewrites = bridgeWamp.Flag("rewrite", "Rewrite a topic or procedure prefix on the destination").PlaceHolder("OLD=NEW").StringMap()
+	bridgeRate     = bridgeWamp.Flag("rate", "Maximum events forwarded per second (0 for unlimited)").Float64()
+	bridgeQueueDir = bridgeWamp.Flag("queue-dir", "Queue events on disk in this directory while the "+
+		"destination router is down, forwarding them in order once it is back").PlaceHolder("DIR").String()
+
+	codegen        = kingpin.Command("codegen", "Generate client stubs from the procedures registered on the realm.")
+	codegenFormat  = codegen.Flag("format", "Output Go stubs or a JSON descriptor").Default("go").Enum("go", "json")
+	codegenPackage = codegen.Flag("package", "Package name of the generated Go code").Default("client").String()
+	codegenOutput  = codegen.Flag("output", "Write to this file instead of stdout").Short('o').String()
+
+	compareSerializers = kingpin.Command("compare-serializers",
+		"Run the same call and publish workload with each serializer and compare sizes and latencies.")
+	compareIterations = compareSerializers.Flag("iterations", "Number of calls and publishes per serializer").
+				Default("100").Int()
+
+	probePermissions = kingpin.Command("probe-permissions",
+		"Try operations on URIs and report which ones the router allows.")
+	probeURIs       = probePermissions.Arg("uris", "URIs to probe (default: discovered on the realm)").Strings()
+	probeOperations = probePermissions.Flag("operation", "Operation to probe (repeatable, default: register "+
+		"and subscribe, call and publish really invoke procedures and publish events)").
+		Default(wick.ProbeRegister, wick.ProbeSubscribe).
+		Enums(wick.ProbeRegister, wick.ProbeCall, wick.ProbeSubscribe, wick.ProbePublish)
+
+	testament           = kingpin.Command("testament", "Manage events the router publishes when the session ends.")
+	testamentAdd        = testament.Command("add", "Add a testament and hold the session open until interrupted.")
+	testamentAddTopic   = testamentAdd.Arg("topic", "Topic to publish to when the session ends").Required().String()
+	testamentAddArgs    = testamentAdd.Arg("args", "give the arguments").Strings()
+	testamentAddKwargs  = testamentAdd.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	testamentAddOptions = testamentAdd.Flag("option", "Set a PUBLISH option").PlaceHolder("KEY=VALUE").StringMap()
+	testamentAddScope   = testamentAdd.Flag("scope", "When to publish the testament").Default(wick.TestamentDestroyed).
+				Enum(wick.TestamentDestroyed, wick.TestamentDetached)
+	testamentFlush      = testament.Command("flush", "Remove the testaments of a new session, to check router support.")
+	testamentFlushScope = testamentFlush.Flag("scope", "Scope of the testaments to remove").
+				Default(wick.TestamentDestroyed).Enum(wick.TestamentDestroyed, wick.TestamentDetached)
+
+	presence         = kingpin.Command("presence", "Announce or watch members of a presence group.")
+	presencePrefix   = presence.Flag("prefix", "Topic prefix of presence events").Default(wick.DefaultPresencePrefix).String()
+	presenceAnnounce = presence.Command("announce", "Join the presence group and send heartbeats until interrupted.")
+	presenceID       = presenceAnnounce.Flag("id", "Id to announce").Required().String()
+	presenceInterval = presenceAnnounce.Flag("interval", "Time between heartbeats").Default("5s").Duration()
+	presenceWatch    = presence.Command("watch", "Log members as they join, leave or miss heartbeats.")
+
+	realmCmd    = kingpin.Command("realm", "Manage realms through the management API of a Crossbar router.")
+	realmPrefix = realmCmd.Flag("management-prefix", "URI prefix of the router worker management procedures").
+			Default(wick.DefaultManagementPrefix).String()
+	realmCreate     = realmCmd.Command("create", "Start a realm.")
+	realmCreateName = realmCreate.Arg("name", "Name of the realm").Required().String()
+	realmCreateRole = realmCreate.Flag("role", "Add a role allowed every operation (repeatable)").Strings()
+	realmDelete     = realmCmd.Command("delete", "Stop a realm.")
+	realmDeleteName = realmDelete.Arg("name", "Name of the realm").Required().String()
+	realmList       = realmCmd.Command("list", "List the realms started on the router worker.")
+
+	top         = kingpin.Command("top", "Show live event rates, session counts and errors on the realm.")
+	topPrefixes = top.Arg("prefixes", "Topic prefixes to show event rates for").Required().Strings()
+	topInterval = top.Flag("interval", "Time between refreshes").Default("1s").Duration()
+
+	sessions         = kingpin.Command("sessions", "Browse, inspect and kill the sessions on the realm.")
+	sessionsAuthrole = sessions.Flag("role-contains", "Only show sessions whose authrole contains this").String()
+	sessionsSort     = sessions.Flag("sort", "Column to sort sessions by, none prints them as they are looked up").
+				Default(wick.SortByID).Enum(wick.SortByID, wick.SortByAuthid, wick.SortByAuthrole, wick.SortNone)
+
+	sessionCmd    = kingpin.Command("session", "Operate on sessions of the realm.")
+	killWhere     = sessionCmd.Command("kill-where", "Kill the sessions matching filters, showing them first.")
+	killWhereRole = killWhere.Flag("role", "Kill sessions with this authrole").String()
+	killWhereUser = killWhere.Flag("user", "Kill sessions with this authid").String()
+	killWhereIdle = killWhere.Flag("idle-longer-than", "Only kill sessions holding no registrations or "+
+		"subscriptions for this long").Duration()
+	killWhereDryRun  = killWhere.Flag("dry-run", "Show the sessions that would be killed without killing them").Bool()
+	killWhereRate    = killWhere.Flag("rate", "Sessions to kill per second").Default("10").Float64()
+	killWhereRetries = killWhere.Flag("retries", "Times to retry killing a session when it fails").Default("2").Int()
 
-	register          = kingpin.Command("register", "Register a procedure.")
-	registerProcedure = register.Arg("procedure", "procedure name").Required().String()
-	onInvocationCmd   = register.Arg("command", "Shell command to run and return it's output").String()
-	delay             = register.Flag("delay", "Register procedure after delay (in seconds)").Int()
-	invokeCount       = register.Flag("invoke-count", "Leave session after it's called requested times").Int()
+	snapshot       = kingpin.Command("snapshot", "Save or compare the sessions, registrations and subscriptions on the realm.")
+	snapshotTake   = snapshot.Command("take", "Save the state of the realm to a JSON file.").Default()
+	snapshotOutput = snapshotTake.Flag("output", "File to write (default: snapshot-REALM-TIME.json)").
+			Short('o').String()
+	snapshotDiff  = snapshot.Command("diff", "Show what changed between two snapshots.")
+	snapshotDiffA = snapshotDiff.Arg("before", "Earlier snapshot").Required().ExistingFile()
+	snapshotDiffB = snapshotDiff.Arg("after", "Later snapshot").Required().ExistingFile()
 
-	call            = kingpin.Command("call", "Call a procedure.")
-	callProcedure   = call.Arg("procedure", "Procedure to call").Required().String()
-	callArgs        = call.Arg("args", "give the arguments").Strings()
-	callKeywordArgs = call.Flag("kwarg", "give the keyword arguments").Short('k').StringMap()
+	decode       = kingpin.Command("decode", "Decode a captured message or payload of --serializer and print it.")
+	decodeSource = decode.Arg("payload", "File holding the payload, or the payload as hex").Required().String()
+	encode       = kingpin.Command("encode", "Encode a JSON message or payload with --serializer.")
+	encodeValue  = encode.Arg("json", "Message or payload as JSON").Required().String()
+	encodeFormat = encode.Flag("format", "Output format").Default(wick.EncodeHex).
+			Enum(wick.EncodeHex, wick.EncodeBase64, wick.EncodeRaw)
+	encodeFrame = encode.Flag("frame", "Add a rawsocket frame header").Bool()
+
+	config         = kingpin.Command("config", "Manage default flag values in ~/.wick/config.")
+	configSetCmd   = config.Command("set", "Set the default value of a global flag.")
+	configSetKey   = configSetCmd.Arg("key", "Flag name, e.g. serializer").Required().String()
+	configSetValue = configSetCmd.Arg("value", "Default value").Required().String()
+	configListCmd  = config.Command("list", "List configurable flags and their defaults.")
+
+	historyCmd  = kingpin.Command("history", "List recent calls and publishes.")
+	historyGrep = historyCmd.Flag("grep", "Only list operations whose URI, payload or command match this "+
+		"regular expression").String()
+	historyLimit = historyCmd.Flag("limit", "List at most this many operations").Default("20").Int()
+	rerunCmd     = kingpin.Command("rerun", "Run a call or publish from the history again.")
+	rerunID      = rerunCmd.Arg("id", "ID of the history entry").Required().Int()
+
+	alias           = kingpin.Command("alias", "Manage command shortcuts stored in the config file.")
+	aliasAddCmd     = alias.Command("add", "Add an alias for a command with arguments.")
+	aliasAddName    = aliasAddCmd.Arg("name", "Name of the alias").Required().String()
+	aliasAddCommand = aliasAddCmd.Arg("command", "Command it runs, e.g. 'call com.ops.restart --kwarg worker='").
+			Required().String()
+	aliasRemoveCmd  = alias.Command("remove", "Remove an alias.")
+	aliasRemoveName = aliasRemoveCmd.Arg("name", "Name of the alias").Required().String()
+	aliasListCmd    = alias.Command("list", "List the aliases.")
+
+	selfUpdateCmd   = kingpin.Command("self-update", "Update wick to the latest release.")
+	selfUpdateForce = selfUpdateCmd.Flag("force", "Reinstall the latest release even if it is installed").Bool()
+
+	version      = kingpin.Command("version", "Show version information.")
+	versionJSON  = version.Flag("json", "print version information as JSON").Bool()
+	versionCheck = version.Flag("check", "check GitHub for a newer release").Bool()
 )
 
-const versionString = "0.3.0"
+// These are set at build time, e.g. with
+// -ldflags "-X main.versionString=0.3.0 -X main.gitCommit=<sha> -X main.buildDate=<date>".
+// A plain go build is reported as a development build.
+var (
+	versionString = "0.0.0-dev"
+	gitCommit     = "unknown"
+	buildDate     = "unknown"
+)
+
+func defaultAgent() string {
+	return fmt.Sprintf("wick/%s (%s)", versionString, gitCommit)
+}
 
 func main() {
 	kingpin.Version(versionString).VersionFlag.Short('v')
-	cmd := kingpin.Parse()
+	kingpin.Flag("describe-flags", "Print the command and flag tree as JSON").Hidden().
+		PreAction(describeFlags).Bool()
+	if err := applyConfigDefaults(); err != nil {
+		logrus.Fatal(err)
+	}
+	args, err := expandAlias(os.Args[1:])
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	cmd := kingpin.MustParse(kingpin.CommandLine.Parse(args))
+
+	switch cmd {
+	case version.FullCommand():
+		printVersion(*versionJSON, *versionCheck)
+		return
+	case selfUpdateCmd.FullCommand():
+		selfUpdate(*selfUpdateForce)
+		return
+	case configSetCmd.FullCommand():
+		if err := configSet(*configSetKey, *configSetValue); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case configListCmd.FullCommand():
+		if err := configList(); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case historyCmd.FullCommand():
+		if err := printHistory(*historyGrep, *historyLimit); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case rerunCmd.FullCommand():
+		if err := rerun(*rerunID); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case aliasAddCmd.FullCommand():
+		if err := aliasAdd(*aliasAddName, *aliasAddCommand); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case aliasRemoveCmd.FullCommand():
+		if err := aliasRemove(*aliasRemoveName); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	case aliasListCmd.FullCommand():
+		if err := aliasList(); err != nil {
+			logrus.Fatal(err)
+		}
+		return
+	}
+
+	if *printFull {
+		wick.SetMaxPrintBytes(0)
+	} else {
+		wick.SetMaxPrintBytes(*maxPrintBytes)
+	}
+
+	logger := logrus.New()
+
+	level, _ := logrus.ParseLevel(*logLevel)
+	logger.SetLevel(level)
+	wick.SetLogLevel(level)
+
+	if *noColor {
+		wick.SetColor(false)
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	}
+
+	if *label != "" {
+		logger.AddHook(wick.NewLabelHook(*label))
+		wick.SetLabel(*label)
+	}
+
+	if *transform != "" {
+		if err := wick.SetTransform(*transform); err != nil {
+			logger.Fatal("Invalid --transform: ", err)
+		}
+	}
+
+	if len(*sealKwargs) > 0 {
+		if err := wick.EnableSealing(*sealKwargs, *sealKey); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if *chaos != "" {
+		if err := wick.SetChaos(*chaos); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if *throttle != "" {
+		if err := wick.SetThrottle(*throttle); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if *jitter != "" {
+		if err := wick.SetJitter(*jitter); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	if *progressJSON {
+		wick.EnableProgress(os.Stderr)
+	}
+
+	if *pprofAddr != "" {
+		wick.ServePprof(*pprofAddr)
+	}
+
+	if *seed != 0 {
+		wick.SetSeed(*seed)
+	}
+	logger.Debugf("Using seed %d\n", wick.Seed())
+
+	if *correlationID == "" {
+		*correlationID = wick.NewCorrelationID()
+	}
+	wick.SetCorrelationID(*correlationID, *correlationKwarg)
+
+	if err := wick.SetMetaConcurrency(*metaConcurrency); err != nil {
+		logger.Fatal(err)
+	}
+
+	wick.SetURIPrefix(*uriPrefix)
+	wick.SetStrictArgs(*strictArgs)
+	resolveURIs(logger)
+
+	if *validate {
+		wick.EnableValidation(*schemaDir)
+	}
 
 	serializerToUse := serialize.JSON
 
@@ -93,7 +535,38 @@ func main() {
 		serializerToUse = serialize.CBOR
 	}
 
-	logger := logrus.New()
+	if cmd == snapshotDiff.FullCommand() {
+		before, err := wick.ReadSnapshot(*snapshotDiffA)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		after, err := wick.ReadSnapshot(*snapshotDiffB)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if wick.DiffSnapshots(before, after, os.Stdout) {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cmd == decode.FullCommand() {
+		data, err := wick.ReadPayload(*decodeSource)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err = wick.Decode(serializerToUse, data); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
+
+	if cmd == encode.FullCommand() {
+		if err := wick.Encode(serializerToUse, *encodeValue, *encodeFormat, *encodeFrame, os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+		return
+	}
 
 	if *privateKey != "" && *ticket != "" {
 		logger.Fatal("Provide only one of private key, ticket or secret")
@@ -111,7 +584,54 @@ func main() {
 		*authMethod = "wampcra"
 	}
 
-	var session *client.Client
+	if *agent == "" {
+		*agent = defaultAgent()
+	}
+
+	clientInfo := &wick.ClientInfo{
+		Url:          *url,
+		Realm:        *realm,
+		Serializer:   serializerToUse,
+		Authid:       *authid,
+		Authrole:     *authrole,
+		Agent:        *agent,
+		HelloDetails: wick.DictToWampDict(*helloDetails),
+		ServerName:   *sni,
+		NextProtos:   *alpn,
+		PinnedSHA256: *pinSHA256,
+		KeepAlive:    *keepAlive,
+		MaxMsgSize:   *maxMsgSize,
+		Proxy:        *proxy,
+
+		ConnectTimeout:  *connectTimeout,
+		ResponseTimeout: *responseTimeout,
+	}
+	if clientInfo.Headers, err = wick.ParseHeaders(*headers); err != nil {
+		logger.Fatal(err)
+	}
+	if clientInfo.ConnectTimeout < 0 || clientInfo.ResponseTimeout < 0 {
+		logger.Fatal("timeouts must not be negative")
+	}
+	if err := wick.ValidateKeepAlive(clientInfo.KeepAlive); err != nil {
+		logger.Fatal(err)
+	}
+	if err := wick.ValidateMaxMsgSize(clientInfo.MaxMsgSize); err != nil {
+		logger.Fatal(err)
+	}
+	if clientInfo.Proxy != "" {
+		if err := wick.ValidateProxy(clientInfo.Proxy); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	tlsConfig, err := wick.LoadTLSConfig(*tlsCA, *tlsClientCert, *tlsClientKey, *tlsInsecure)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	if *tlsInsecure {
+		logger.Warn("Not verifying the router certificate, the connection can be intercepted")
+	}
+	clientInfo.TLSConfig = tlsConfig
 
 	switch *authMethod {
 	case "anonymous":
@@ -124,34 +644,616 @@ func main() {
 		if *secret != "" {
 			logger.Fatal("secret not needed for anonymous auth")
 		}
-		session = wick.ConnectAnonymous(*url, *realm, serializerToUse, *authid, *authrole)
 	case "ticket":
-		if *ticket == "" {
+		if *ticket == "" && *runSecretsFile == "" {
 			logger.Fatal("Must provide ticket when authMethod is ticket")
 		}
-		session = wick.ConnectTicket(*url, *realm, serializerToUse, *authid, *authrole, *ticket)
 	case "wampcra":
-		if *secret == "" {
+		if *secret == "" && *runSecretsFile == "" {
 			logger.Fatal("Must provide secret when authMethod is wampcra")
 		}
-		session = wick.ConnectCRA(*url, *realm, serializerToUse, *authid, *authrole, *secret)
 	case "cryptosign":
 		if *privateKey == "" {
 			logger.Fatal("Must provide private key when authMethod is cryptosign")
 		}
-		session = wick.ConnectCryptoSign(*url, *realm, serializerToUse, *authid, *authrole, *privateKey)
 	}
 
+	if cmd == call.FullCommand() || cmd == publish.FullCommand() {
+		wick.EnableHistory(defaultHistoryPath(), *historySize, redactCommand(args, *sealKwargs), clientInfo.Url,
+			clientInfo.Realm)
+	}
+
+	if *auditEnabled {
+		if err := wick.EnableAudit(*auditLog); err != nil {
+			logger.Fatal("Failed to open audit log: ", err)
+		}
+	}
+
+	if *protected && !*yes {
+		topics := splitPatterns(*protectedTopics)
+		procedures := append(splitPatterns(*blockedProcedures), sessionKillProcedures...)
+		switch cmd {
+		case publish.FullCommand(), call.FullCommand():
+			// Topics and procedures are checked once their templates are
+			// expanded, for every iteration of --repeat.
+			wick.SetURICheck(protectedURIs(logger, topics, procedures))
+		case subscribe.FullCommand():
+			if *subscribeTee != "" {
+				confirmProtected(logger, "publish to", *subscribeTee, topics)
+			}
+			if *subscribeEventCall != "" {
+				confirmProtected(logger, "call", *subscribeEventCall, procedures)
+			}
+			confirmProtectedTestaments(logger, *subscribeTestaments, topics)
+		case register.FullCommand():
+			confirmProtectedTestaments(logger, *registerTestaments, topics)
+		case testamentAdd.FullCommand():
+			confirmProtected(logger, "publish to", *testamentAddTopic, topics)
+		case testamentFlush.FullCommand():
+			confirmProtectedCommand(logger, "remove the testaments of the session")
+		case bridgeWamp.FullCommand():
+			confirmProtectedCommand(logger, "bridge events and calls to realm "+*bridgeToRealm)
+		case compareSerializers.FullCommand():
+			confirmProtectedCommand(logger, "compare serializers by calling procedures and publishing events")
+		case presenceAnnounce.FullCommand():
+			confirmProtectedCommand(logger, "announce presence as "+*presenceID)
+		case realmCreate.FullCommand():
+			confirmProtectedCommand(logger, "create realm "+*realmCreateName)
+		case run.FullCommand():
+			confirmProtectedCommand(logger, "run scenario "+strings.Join(*runScripts, ", "))
+		case realmDelete.FullCommand():
+			confirmProtectedCommand(logger, "delete realm "+*realmDeleteName)
+		case probePermissions.FullCommand():
+			for _, operation := range *probeOperations {
+				if operation == wick.ProbeCall || operation == wick.ProbePublish {
+					confirmProtectedCommand(logger, "probe by calling procedures and publishing events")
+					break
+				}
+			}
+		}
+	}
+
+	if cmd == run.FullCommand() {
+		runScenarios(clientInfo, logger)
+		return
+	}
+
+	if cmd == compareSerializers.FullCommand() {
+		if *compareIterations < 1 {
+			logger.Fatal("--iterations must be at least 1")
+		}
+		wick.CompareSerializers(func(serializer serialize.Serialization) *client.Client {
+			info := *clientInfo
+			info.Serializer = serializer
+			return connect(&info)
+		}, *compareIterations, wick.RunMetadata{Version: versionString, URL: clientInfo.Url,
+			Realm: clientInfo.Realm, Seed: wick.Seed()}, os.Stdout)
+		return
+	}
+
+	if cmd == bridgeWamp.FullCommand() {
+		if len(*bridgeTopics) == 0 && len(*bridgeProcedures) == 0 {
+			logger.Fatal("Provide at least one --topic or --procedure to bridge")
+		}
+
+		destinationInfo := *clientInfo
+		destinationInfo.Realm = *bridgeToRealm
+		if *bridgeToURL != "" {
+			destinationInfo.Url = *bridgeToURL
+		}
+
+		if *bridgeQueueDir != "" {
+			if err := wick.SetBridgeQueue(*bridgeQueueDir); err != nil {
+				logger.Fatal(err)
+			}
+		}
+
+		source := connect(clientInfo)
+		defer source.Close()
+		destination := connect(&destinationInfo)
+		defer destination.Close()
+
+		if *healthAddr != "" {
+			wick.ServeHealth(*healthAddr, source)
+		}
+		wick.Bridge(source, destination, *bridgeTopics, *bridgeMatch, *bridgeProcedures, *bridgeRewrites,
+			*bridgeRate)
+		return
+	}
+
+	session := connect(clientInfo)
 	defer session.Close()
 
+	if *healthAddr != "" && (cmd == subscribe.FullCommand() || cmd == register.FullCommand()) {
+		wick.ServeHealth(*healthAddr, session)
+	}
+
 	switch cmd {
 	case subscribe.FullCommand():
-		wick.Subscribe(session, *subscribeTopic, *subscribeMatch, *subscribePrintDetails)
+		if *subscribeBuffer < 0 {
+			logger.Fatal("--buffer must not be negative")
+		}
+		if *subscribeOnOverflow == wick.OverflowBlock && (*subscribeTee != "" || *subscribeEventCall != "") {
+			logger.Fatal("--on-overflow block cannot be used with --tee or --on-event-call, which need " +
+				"the router's replies that blocking holds up")
+		}
+		topics := *subscribeTopics
+		if *subscribeTopicsFile != "" {
+			fileTopics, err := readLines(*subscribeTopicsFile)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			for _, topic := range fileTopics {
+				topics = append(topics, mustResolveURI(logger, topic))
+			}
+		}
+		if len(topics) == 0 {
+			logger.Fatal("Provide at least one topic or --topics-file")
+		}
+		addTestaments(logger, session, *subscribeTestaments)
+		if *subscribeReconnect {
+			wick.EnableReconnect(wick.DefaultMaxReconnectBackoff)
+		}
+		if *subscribeEventCall != "" {
+			wick.EnableEventCall(*subscribeEventCall)
+		}
+		if *subscribeTee != "" {
+			if err := wick.EnableTee(*subscribeTee, *subscribeTeeTemplate); err != nil {
+				logger.Fatal("Invalid --tee-template: ", err)
+			}
+		}
+		wick.Subscribe(session, topics, *subscribeMatch, *subscribePrintDetails, *subscribeBuffer,
+			*subscribeOnOverflow, *subscribeOptions)
 	case publish.FullCommand():
-		wick.Publish(session, *publishTopic, *publishArgs, *publishKeywordArgs)
+		if *publishRepeat < 1 {
+			logger.Fatal("--repeat must be at least 1")
+		}
+		if rows := loadDataRows(logger, *publishDataCSV, *publishDataDir); rows > 0 && *publishRepeat == 1 {
+			*publishRepeat = rows
+		}
+		if *publishCSV != "" {
+			if err = wick.EnableCSV(*publishCSV); err != nil {
+				logger.Fatal("Failed to create CSV file: ", err)
+			}
+		}
+		if *publishTickTopic != "" || *publishTickStdin {
+			enableTicks(logger, session, *publishTickTopic, *publishTickStdin)
+		}
+		if *publishRepeat > 1 || *publishCSV != "" || *publishTickTopic != "" || *publishTickStdin {
+			wick.PublishRepeated(session, *publishTopic, *publishArgs, *publishKeywordArgs, *publishOptions,
+				*publishRepeat)
+			return
+		}
+		wick.Publish(session, *publishTopic, *publishArgs, *publishKeywordArgs, *publishOptions)
 	case register.FullCommand():
-		wick.Register(session, *registerProcedure, *onInvocationCmd, *delay, *invokeCount)
+		procedures := map[string]string{}
+		if *registerProcedure != "" {
+			procedures[*registerProcedure] = *onInvocationCmd
+		}
+		for procedure, command := range *registerProcedures {
+			procedures[procedure] = command
+		}
+		if len(procedures) == 0 {
+			logger.Fatal("Provide a procedure or at least one --procedure")
+		}
+		addTestaments(logger, session, *registerTestaments)
+		if *registerReconnect {
+			wick.EnableReconnect(wick.DefaultMaxReconnectBackoff)
+		}
+		if *forceReregister {
+			(*registerOptions)["force_reregister"] = "true"
+		}
+		wick.Register(session, procedures, *delay, *invokeCount, *registerOptions, *yieldArgs, *yieldKwargs,
+			*responseDelay)
 	case call.FullCommand():
-		wick.Call(session, *callProcedure, *callArgs, *callKeywordArgs)
+		if *callCache < 0 {
+			logger.Fatal("--cache must not be negative")
+		}
+		if *callCache > 0 && *callResultFile != "" {
+			logger.Fatal("--cache cannot be used with --result-to-file")
+		}
+		wick.EnableCache(defaultCacheDir(), *callCache, *callBypassCache, clientInfo.Url, clientInfo.Realm)
+		if *callRepeat < 1 || *callParallel < 1 || *callSessions < 1 {
+			logger.Fatal("--repeat, --parallel and --sessions must be at least 1")
+		}
+		rows := loadDataRows(logger, *callDataCSV, *callDataDir)
+		if rows > 0 && *callRepeat == 1 {
+			*callRepeat = rows
+		}
+		ticked := *callTickTopic != "" || *callTickStdin
+		if *callRepeat > 1 || *callAggregate != "" || rows > 0 || *callCSV != "" || ticked {
+			if *callExpectArgs != "" || *callExpectKwargs != "" || *callExpectError != "" || *callResultFile != "" ||
+				*callCache > 0 {
+				logger.Fatal("--repeat, --aggregate, --csv, --tick-* and --data-* cannot be used with " +
+					"--expect-*, --result-to-file or --cache")
+			}
+			if ticked {
+				enableTicks(logger, session, *callTickTopic, *callTickStdin)
+			}
+			if *callCSV != "" {
+				if err = wick.EnableCSV(*callCSV); err != nil {
+					logger.Fatal("Failed to create CSV file: ", err)
+				}
+			}
+			var aggregation *wick.Aggregation
+			if *callAggregate != "" {
+				if aggregation, err = wick.ParseAggregation(*callAggregate); err != nil {
+					logger.Fatal(err)
+				}
+			}
+			sessions := []*client.Client{session}
+			for len(sessions) < *callSessions && len(sessions) < *callParallel {
+				extra := connect(clientInfo)
+				defer extra.Close()
+				sessions = append(sessions, extra)
+			}
+			wick.CallRepeated(sessions, *callProcedure, *callArgs, *callKeywordArgs, *callOptions, *callRepeat,
+				*callParallel, aggregation)
+			return
+		}
+		expect := wick.Expectation{Args: *callExpectArgs, Kwargs: *callExpectKwargs, Error: *callExpectError}
+		wick.Call(session, *callProcedure, *callArgs, *callKeywordArgs, *callOptions, expect, *callResultFile)
+	case testamentAdd.FullCommand():
+		wick.HoldTestament(session, wick.Testament{Topic: *testamentAddTopic, Args: *testamentAddArgs,
+			Kwargs: *testamentAddKwargs, Options: *testamentAddOptions, Scope: *testamentAddScope})
+	case testamentFlush.FullCommand():
+		if err := wick.FlushTestaments(session, *testamentFlushScope); err != nil {
+			logger.Fatal(err)
+		}
+	case top.FullCommand():
+		if *topInterval <= 0 {
+			logger.Fatal("--interval must be positive")
+		}
+		for _, prefix := range *topPrefixes {
+			if prefix == "" {
+				logger.Fatal("topic prefixes must not be empty, routers reject an empty prefix subscription")
+			}
+		}
+		wick.Top(session, *topPrefixes, *topInterval, os.Stdout)
+	case snapshotTake.FullCommand():
+		path := *snapshotOutput
+		if path == "" {
+			path = wick.SnapshotFileName(*realm, time.Now())
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		summary, err := wick.TakeSnapshot(session, *realm, file)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			logger.Fatal(err)
+		}
+		logger.Infof("Wrote snapshot of %d sessions, %d registrations and %d subscriptions to %s\n",
+			summary.Sessions, summary.Registrations, summary.Subscriptions, path)
+	case killWhere.FullCommand():
+		if *killWhereRole == "" && *killWhereUser == "" {
+			// Callers and publishers hold nothing, so they look idle however busy they are.
+			logger.Fatal("Provide --role or --user, --idle-longer-than can't tell callers and publishers " +
+				"from idle sessions")
+		}
+		// Also rejects NaN, which ParseFloat accepts.
+		if !(*killWhereRate > 0) {
+			logger.Fatal("--rate must be positive")
+		}
+		plan, err := wick.PlanKill(session, wick.KillFilter{Authrole: *killWhereRole, Authid: *killWhereUser,
+			IdleLongerThan: *killWhereIdle})
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if plan.Len() == 0 {
+			logger.Info("No sessions match")
+			return
+		}
+		plan.Write(os.Stdout)
+		if *killWhereDryRun {
+			logger.Infof("Dry run, would kill %d sessions\n", plan.Len())
+			return
+		}
+		if !*yes {
+			confirmKill(logger, plan.Len())
+		}
+		result := plan.Execute(session, *killWhereRate, *killWhereRetries)
+		logger.Infof("Killed %d sessions, %d already gone, %d failed, %d not tried\n", result.Killed, result.Gone,
+			result.Failed, result.Remaining)
+		if result.Failed > 0 || result.Remaining > 0 {
+			os.Exit(1)
+		}
+	case sessions.FullCommand():
+		wick.BrowseSessions(session, *sessionsAuthrole, *sessionsSort, *protected)
+	case presenceAnnounce.FullCommand():
+		wick.AnnouncePresence(session, *presencePrefix, *presenceID, *presenceInterval)
+	case presenceWatch.FullCommand():
+		wick.WatchPresence(session, *presencePrefix)
+	case realmCreate.FullCommand():
+		if err := wick.CreateRealm(session, *realmPrefix, *realmCreateName, *realmCreateRole); err != nil {
+			logger.Fatal(err)
+		}
+	case realmDelete.FullCommand():
+		if err := wick.DeleteRealm(session, *realmPrefix, *realmDeleteName); err != nil {
+			logger.Fatal(err)
+		}
+	case realmList.FullCommand():
+		if err := wick.ListRealms(session, *realmPrefix, os.Stdout); err != nil {
+			logger.Fatal(err)
+		}
+	case probePermissions.FullCommand():
+		wick.ProbePermissions(session, *probeURIs, *probeOperations, os.Stdout)
+	case codegen.FullCommand():
+		output := os.Stdout
+		if *codegenOutput != "" {
+			file, err := os.Create(*codegenOutput)
+			if err != nil {
+				logger.Fatal(err)
+			}
+			defer file.Close()
+			output = file
+		}
+		wick.Codegen(session, *codegenFormat, *codegenPackage, *schemaDir, output)
+	}
+}
+
+func connect(clientInfo *wick.ClientInfo) *client.Client {
+	switch *authMethod {
+	case "ticket":
+		return wick.ConnectTicket(clientInfo, *ticket)
+	case "wampcra":
+		return wick.ConnectCRA(clientInfo, *secret)
+	case "cryptosign":
+		return wick.ConnectCryptoSign(clientInfo, *privateKey)
+	default:
+		return wick.ConnectAnonymous(clientInfo)
+	}
+}
+
+// resolveURIs expands the relative URIs given on the command line with
+// --uri-prefix.
+func resolveURIs(logger *logrus.Logger) {
+	for _, uri := range []*string{subscribeTee, subscribeEventCall, publishTopic, publishTickTopic,
+		registerProcedure, callProcedure, callTickTopic, testamentAddTopic} {
+		*uri = mustResolveURI(logger, *uri)
+	}
+	for _, uris := range [][]string{*subscribeTopics, *bridgeTopics, *bridgeProcedures, *probeURIs} {
+		for i := range uris {
+			uris[i] = mustResolveURI(logger, uris[i])
+		}
+	}
+	procedures := map[string]string{}
+	for procedure, command := range *registerProcedures {
+		procedures[mustResolveURI(logger, procedure)] = command
+	}
+	*registerProcedures = procedures
+}
+
+func mustResolveURI(logger *logrus.Logger, uri string) string {
+	resolved, err := wick.ResolveURI(uri)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return resolved
+}
+
+// enableTicks paces repeated calls and publishes by the events of topic, or
+// by the lines of stdin.
+func enableTicks(logger *logrus.Logger, session *client.Client, topic string, stdin bool) {
+	if topic != "" && stdin {
+		logger.Fatal("Provide only one of --tick-topic or --tick-stdin")
+	}
+	if stdin {
+		wick.EnableTickStdin()
+		return
+	}
+	if err := wick.EnableTickTopic(session, topic); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// addTestaments registers the testaments given as --testament specs.
+func addTestaments(logger *logrus.Logger, session *client.Client, specs []string) {
+	for _, spec := range specs {
+		testament, err := wick.ParseTestament(spec)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err = wick.AddTestament(session, testament); err != nil {
+			logger.Fatal(err)
+		}
+	}
+}
+
+// runScenarios runs each scenario script once per instance, each instance
+// with its own session. Scripts run one after the other, or all at once with
+// --parallel-files. It exits non-zero if any instance fails.
+func runScenarios(clientInfo *wick.ClientInfo, logger *logrus.Logger) {
+	if *runInstances < 1 {
+		logger.Fatal("--instances must be at least 1")
+	}
+
+	var credentials []credential
+	if *runSecretsFile != "" {
+		if *authMethod != "wampcra" && *authMethod != "ticket" {
+			logger.Fatalf("--secrets-file needs --authmethod wampcra or ticket, not %s", *authMethod)
+		}
+		var err error
+		if credentials, err = readSecretsFile(*runSecretsFile); err != nil {
+			logger.Fatal(err)
+		}
+	}
+
+	scripts := *runScripts
+	failures := make([]int32, len(scripts))
+	if *runParallelFiles {
+		var wg sync.WaitGroup
+		for i, script := range scripts {
+			wg.Add(1)
+			go func(i int, script string) {
+				defer wg.Done()
+				failures[i] = runScenario(clientInfo, logger, script, credentials)
+			}(i, script)
+		}
+		wg.Wait()
+	} else {
+		for i, script := range scripts {
+			failures[i] = runScenario(clientInfo, logger, script, credentials)
+		}
+	}
+
+	failed := false
+	for i, script := range scripts {
+		if len(scripts) > 1 {
+			logger.Infof("%s: %d of %d instances failed\n", script, failures[i], *runInstances)
+		}
+		failed = failed || failures[i] > 0
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// runScenario runs script once per instance, each instance with its own
+// session, and returns how many instances failed.
+func runScenario(clientInfo *wick.ClientInfo, logger *logrus.Logger, script string,
+	credentials []credential) int32 {
+	var wg sync.WaitGroup
+	var failed int32
+
+	for i := 0; i < *runInstances; i++ {
+		wg.Add(1)
+		go func(instance int) {
+			defer wg.Done()
+
+			if *runInstances > 1 {
+				wick.Jitter()
+			}
+			tag := scenarioTag(script, instance, "")
+			var session *client.Client
+			if len(credentials) > 0 {
+				cred := credentials[instance%len(credentials)]
+				tag = scenarioTag(script, instance, cred.authid)
+				session = connectAs(clientInfo, cred)
+			} else {
+				session = connect(clientInfo)
+			}
+			defer session.Close()
+
+			if err := wick.RunScenario(session, script, instance, tag); err != nil {
+				authid, _ := wamp.AsString(session.RealmDetails()["authid"])
+				log := logger.WithFields(logrus.Fields{"instance": instance, "authid": authid})
+				if len(*runScripts) > 1 {
+					log.Errorf("%s instance %d: %v", script, instance, err)
+				} else {
+					log.Errorf("instance %d: %v", instance, err)
+				}
+				atomic.AddInt32(&failed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	return failed
+}
+
+// scenarioTag identifies the output of a scenario instance by the process
+// label, the script if there are several, the instance index and the authid
+// it joined as, if any. It is empty for a single unlabeled instance of a
+// single script.
+func scenarioTag(script string, instance int, authid string) string {
+	if *runInstances == 1 && *label == "" && len(*runScripts) == 1 {
+		return ""
+	}
+
+	tag := fmt.Sprintf("instance %d", instance)
+	if authid != "" {
+		tag += " " + authid
+	}
+	if len(*runScripts) > 1 {
+		tag = script + " " + tag
+	}
+	if *label != "" {
+		tag = *label + " " + tag
+	}
+	return tag
+}
+
+// credential is an authid with its wampcra secret or ticket.
+type credential struct {
+	authid string
+	secret string
+}
+
+// readSecretsFile reads lines of whitespace separated authid and secret.
+func readSecretsFile(path string) ([]credential, error) {
+	lines, err := readLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := make([]credential, 0, len(lines))
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s: entry %d: expected 'authid secret'", path, i+1)
+		}
+		credentials = append(credentials, credential{authid: fields[0], secret: fields[1]})
+	}
+	if len(credentials) == 0 {
+		return nil, fmt.Errorf("%s: no credentials", path)
+	}
+	return credentials, nil
+}
+
+// connectAs joins as the principal of cred, using ticket authentication or
+// wampcra, whichever was requested.
+func connectAs(clientInfo *wick.ClientInfo, cred credential) *client.Client {
+	info := *clientInfo
+	info.Authid = cred.authid
+	switch *authMethod {
+	case "ticket":
+		return wick.ConnectTicket(&info, cred.secret)
+	case "wampcra":
+		return wick.ConnectCRA(&info, cred.secret)
+	default:
+		logrus.Fatalf("Cannot authenticate with %s from a secrets file", *authMethod)
+		return nil
+	}
+}
+
+// loadDataRows reads the rows of repeated calls or publishes from the CSV
+// file at csvPath or the JSON files in dir, if either is given, and returns
+// how many there are.
+func loadDataRows(logger *logrus.Logger, csvPath string, dir string) int {
+	var rows []wick.DataRow
+	var err error
+	switch {
+	case csvPath != "" && dir != "":
+		logger.Fatal("--data-csv and --data-dir cannot be used together")
+	case csvPath != "":
+		rows, err = wick.ReadDataCSV(csvPath)
+	case dir != "":
+		rows, err = wick.ReadDataDir(dir)
+	}
+	if err != nil {
+		logger.Fatal(err)
+	}
+	wick.SetDataRows(rows)
+	return len(rows)
+}
+
+// readLines returns the non-empty lines of the file at path, skipping
+// comments starting with #.
+func readLines(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
 	}
+	return lines, nil
 }
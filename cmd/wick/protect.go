@@ -0,0 +1,146 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	wick "github.com/s-things/wick/wamp"
+)
+
+// sessionKillProcedures are the meta procedures that are always treated as
+// destructive when running in protected mode.
+var sessionKillProcedures = []string{
+	"wamp.session.kill",
+	"wamp.session.kill_by_authid",
+	"wamp.session.kill_by_authrole",
+	"wamp.session.kill_all",
+	"wamp.registration.remove",
+	"wamp.subscription.remove",
+}
+
+// splitPatterns splits a comma separated list of URI patterns.
+func splitPatterns(patterns string) []string {
+	var result []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			result = append(result, pattern)
+		}
+	}
+	return result
+}
+
+// matchesAny reports whether uri matches one of the glob patterns, where *
+// matches any sequence of characters.
+func matchesAny(uri string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, uri); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmProtected asks for confirmation before publishing to a protected
+// topic or calling a blocked procedure, and exits if it is not given.
+func confirmProtected(logger *logrus.Logger, operation string, uri string, patterns []string) {
+	if !matchesAny(uri, patterns) {
+		return
+	}
+
+	confirm(logger, fmt.Sprintf("%s '%s' on a protected connection", operation, uri),
+		fmt.Sprintf("'%s' is protected on %s (realm %s). Really %s it?", uri, *url, *realm, operation))
+}
+
+// protectedURIs returns a check for wick.SetURICheck that confirms publishing
+// to a protected topic or calling a blocked procedure, asking once for each
+// URI.
+func protectedURIs(logger *logrus.Logger, topics []string, procedures []string) func(string, string) {
+	var mu sync.Mutex
+	confirmed := make(map[string]bool)
+	return func(operation string, uri string) {
+		// Parallel calls check their procedures concurrently.
+		mu.Lock()
+		defer mu.Unlock()
+		if confirmed[operation+" "+uri] {
+			return
+		}
+		if operation == "publish" {
+			confirmProtected(logger, "publish to", uri, topics)
+		} else {
+			confirmProtected(logger, operation, uri, procedures)
+		}
+		confirmed[operation+" "+uri] = true
+	}
+}
+
+// confirmProtectedTestaments asks for confirmation before adding testaments
+// that publish to protected topics, and exits if it is not given.
+func confirmProtectedTestaments(logger *logrus.Logger, specs []string, topics []string) {
+	for _, spec := range specs {
+		// Invalid specs are reported when the testaments are added.
+		if testament, err := wick.ParseTestament(spec); err == nil {
+			confirmProtected(logger, "publish to", testament.Topic, topics)
+		}
+	}
+}
+
+// confirmProtectedCommand asks for confirmation before running a command
+// that can't be limited to protected URIs, such as deleting a realm, and
+// exits if it is not given.
+func confirmProtectedCommand(logger *logrus.Logger, what string) {
+	confirm(logger, what+" on a protected connection",
+		fmt.Sprintf("%s (realm %s) is protected. Really %s?", *url, *realm, what))
+}
+
+// confirmKill asks for confirmation before killing count sessions, and exits
+// if it is not given.
+func confirmKill(logger *logrus.Logger, count int) {
+	confirm(logger, fmt.Sprintf("kill %d sessions", count),
+		fmt.Sprintf("Really kill these %d sessions on %s (realm %s)?", count, *url, *realm))
+}
+
+// confirm asks question on the terminal and exits unless it is answered with
+// yes. Without a terminal, it refuses to do what.
+func confirm(logger *logrus.Logger, what string, question string) {
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		logger.Fatalf("Refusing to %s without a terminal, use --yes", what)
+	}
+
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+	default:
+		logger.Fatal("Aborted")
+	}
+}
@@ -0,0 +1,74 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestProtectedURIs(t *testing.T) {
+	// Without a terminal on stdin, protected URIs are refused.
+	stdin, _, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(original *os.File) { os.Stdin = original }(os.Stdin)
+	os.Stdin = stdin
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	var refused []string
+	logger.ExitFunc = func(int) { panic("refused") }
+	check := protectedURIs(logger, []string{"com.prod.*"}, []string{"billing.*", "wamp.session.kill"})
+	checkRefused := func(operation string, uri string) {
+		defer func() {
+			if recover() != nil {
+				refused = append(refused, operation+" "+uri)
+			}
+		}()
+		check(operation, uri)
+	}
+
+	checkRefused("publish", "com.test.reset")
+	checkRefused("publish", "com.prod.reset")
+	checkRefused("publish", "billing.charge")
+	checkRefused("call", "billing.charge")
+	checkRefused("call", "com.prod.reset")
+	checkRefused("call", "wamp.session.kill")
+
+	expected := []string{"publish com.prod.reset", "call billing.charge", "call wamp.session.kill"}
+	if len(refused) != len(expected) {
+		t.Fatalf("refused %q, expected %q", refused, expected)
+	}
+	for i := range expected {
+		if refused[i] != expected[i] {
+			t.Errorf("refused %q, expected %q", refused, expected)
+		}
+	}
+}
@@ -0,0 +1,303 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Archive names follow the replacements in .goreleaser.yml.
+var (
+	releaseOS   = map[string]string{"darwin": "macOS", "linux": "Linux", "windows": "Windows"}
+	releaseArch = map[string]string{"amd64": "x86_64", "arm64": "arm64"}
+)
+
+// releasePublicKey is the base64 ed25519 key that signs the release checksums,
+// see the signs section of .goreleaser.yml. It is the last 32 bytes of
+// openssl pkey -in <private key> -pubout -outform DER.
+const releasePublicKey = "2e05bJsj54oWL23LfkZ1EThEzIediNhkZHRsptGdxiY="
+
+// selfUpdate replaces the running executable with the latest release for
+// this platform, after verifying its sha256 against the release checksums
+// and their signature against releasePublicKey.
+func selfUpdate(force bool) {
+	latest, err := latestRelease()
+	if err != nil {
+		logrus.Fatal("Failed to check for updates: ", err)
+	}
+
+	latestVersion := strings.TrimPrefix(latest.TagName, "v")
+	if !force {
+		latestSemver, ok := parseVersion(latestVersion)
+		if !ok {
+			logrus.Fatalf("Latest release %s has no semantic version", latest.TagName)
+		}
+		current, _ := parseVersion(versionString)
+		switch {
+		case developmentBuild(versionString):
+			fmt.Printf("wick %s is a development build, use --force to replace it with %s\n", versionString,
+				latestVersion)
+			return
+		case compareVersions(current, latestSemver) == 0:
+			fmt.Printf("wick %s is already the latest version\n", versionString)
+			return
+		case compareVersions(current, latestSemver) > 0:
+			fmt.Printf("wick %s is newer than the latest release %s, use --force to downgrade\n", versionString,
+				latestVersion)
+			return
+		}
+	}
+
+	archiveName, err := releaseArchiveName(latestVersion)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	checksumsName := fmt.Sprintf("wick_%s_checksums.txt", latestVersion)
+	signatureName := checksumsName + ".sig"
+
+	var archiveURL, checksumsURL, signatureURL string
+	for _, asset := range latest.Assets {
+		switch asset.Name {
+		case archiveName:
+			archiveURL = asset.DownloadURL
+		case checksumsName:
+			checksumsURL = asset.DownloadURL
+		case signatureName:
+			signatureURL = asset.DownloadURL
+		}
+	}
+	if archiveURL == "" {
+		logrus.Fatalf("Release %s has no build for %s/%s", latest.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+	if checksumsURL == "" {
+		logrus.Fatalf("Release %s has no checksums, refusing to update", latest.TagName)
+	}
+	if signatureURL == "" {
+		logrus.Fatalf("Release %s has no checksums signature, refusing to update", latest.TagName)
+	}
+
+	checksums, err := download(checksumsURL)
+	if err != nil {
+		logrus.Fatal("Failed to download checksums: ", err)
+	}
+	signature, err := download(signatureURL)
+	if err != nil {
+		logrus.Fatal("Failed to download checksums signature: ", err)
+	}
+	if err = verifySignature(checksums, signature, releasePublicKey); err != nil {
+		logrus.Fatalf("Refusing to update: %s: %s", checksumsName, err)
+	}
+	expected, err := findChecksum(checksums, archiveName)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+
+	logrus.Printf("Downloading %s\n", archiveName)
+	archive, err := download(archiveURL)
+	if err != nil {
+		logrus.Fatal("Failed to download release: ", err)
+	}
+	sum := sha256.Sum256(archive)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		logrus.Fatalf("Checksum mismatch for %s: expected %s, got %s", archiveName, expected, actual)
+	}
+
+	binary, err := extractBinary(archiveName, archive)
+	if err != nil {
+		logrus.Fatal(err)
+	}
+	if err = replaceExecutable(binary); err != nil {
+		logrus.Fatal("Failed to replace executable: ", err)
+	}
+
+	fmt.Printf("Updated wick from %s to %s\n", versionString, latestVersion)
+}
+
+func releaseArchiveName(version string) (string, error) {
+	goos, ok := releaseOS[runtime.GOOS]
+	if !ok {
+		return "", fmt.Errorf("no releases are published for %s", runtime.GOOS)
+	}
+	arch, ok := releaseArch[runtime.GOARCH]
+	if !ok {
+		return "", fmt.Errorf("no releases are published for %s", runtime.GOARCH)
+	}
+
+	extension := "tar.gz"
+	if runtime.GOOS == "windows" {
+		extension = "zip"
+	}
+	return fmt.Sprintf("wick_%s_%s_%s.%s", version, goos, arch, extension), nil
+}
+
+func download(url string) ([]byte, error) {
+	httpClient := &http.Client{Timeout: 5 * time.Minute}
+	response, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response: %s", response.Status)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// verifySignature checks a raw 64 byte ed25519 signature over message, as
+// written by openssl pkeyutl -sign -rawin, against the base64 publicKey.
+func verifySignature(message []byte, signature []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key %q", publicKey)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return fmt.Errorf("invalid signature: expected %d bytes, got %d", ed25519.SignatureSize, len(signature))
+	}
+	if !ed25519.Verify(key, message, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+	return nil
+}
+
+// findChecksum looks up name in a sha256sum style checksums file.
+func findChecksum(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[1] == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum found for %s", name)
+}
+
+func extractBinary(archiveName string, archive []byte) ([]byte, error) {
+	binaryName := "wick"
+	if runtime.GOOS == "windows" {
+		binaryName = "wick.exe"
+	}
+
+	if strings.HasSuffix(archiveName, ".zip") {
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range reader.File {
+			if filepath.Base(file.Name) != binaryName {
+				continue
+			}
+			content, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer content.Close()
+			return io.ReadAll(content)
+		}
+		return nil, fmt.Errorf("%s not found in %s", binaryName, archiveName)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if header.Typeflag == tar.TypeReg && filepath.Base(header.Name) == binaryName {
+			return io.ReadAll(tarReader)
+		}
+	}
+	return nil, fmt.Errorf("%s not found in %s", binaryName, archiveName)
+}
+
+// replaceExecutable atomically swaps the running executable for binary by
+// writing it next to the executable and renaming it into place.
+func replaceExecutable(binary []byte) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if executable, err = filepath.EvalSymlinks(executable); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(executable)
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(filepath.Dir(executable), ".wick-update-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err = temp.Write(binary); err != nil {
+		temp.Close()
+		return err
+	}
+	if err = temp.Close(); err != nil {
+		return err
+	}
+	if err = os.Chmod(temp.Name(), info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	// Windows does not allow replacing a running executable, but allows
+	// renaming it out of the way.
+	if runtime.GOOS == "windows" {
+		old := executable + ".old"
+		_ = os.Remove(old)
+		if err = os.Rename(executable, old); err != nil {
+			return err
+		}
+	}
+
+	return os.Rename(temp.Name(), executable)
+}
@@ -0,0 +1,95 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestFindChecksum(t *testing.T) {
+	checksums := []byte("1111  wick_0.4.0_Linux_x86_64.tar.gz\n" +
+		"2222  wick_0.4.0_Linux_arm64.tar.gz\n" +
+		"\n" +
+		"3333  wick_0.4.0_Windows_x86_64.zip\n")
+
+	tests := []struct {
+		name     string
+		checksum string
+		ok       bool
+	}{
+		{"wick_0.4.0_Linux_x86_64.tar.gz", "1111", true},
+		{"wick_0.4.0_Linux_arm64.tar.gz", "2222", true},
+		{"wick_0.4.0_Windows_x86_64.zip", "3333", true},
+		{"wick_0.4.0_Linux_x86_64.tar", "", false},
+		{"Linux_x86_64.tar.gz", "", false},
+		{"wick_0.4.0_macOS_x86_64.tar.gz", "", false},
+		{"", "", false},
+	}
+	for _, test := range tests {
+		checksum, err := findChecksum(checksums, test.name)
+		if test.ok && (err != nil || checksum != test.checksum) {
+			t.Errorf("findChecksum(%q) = %q, %v, expected %q", test.name, checksum, err, test.checksum)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("findChecksum(%q) = %q, expected an error", test.name, checksum)
+		}
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := base64.StdEncoding.EncodeToString(publicKey)
+	checksums := []byte("1111  wick_0.4.0_Linux_x86_64.tar.gz\n")
+	signature := ed25519.Sign(privateKey, checksums)
+
+	if err = verifySignature(checksums, signature, key); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+	if err = verifySignature([]byte("2222  wick_0.4.0_Linux_x86_64.tar.gz\n"), signature, key); err == nil {
+		t.Error("expected tampered checksums to be rejected")
+	}
+	if err = verifySignature(checksums, signature[:32], key); err == nil {
+		t.Error("expected a truncated signature to be rejected")
+	}
+	if err = verifySignature(checksums, signature, releasePublicKey); err == nil {
+		t.Error("expected a signature by another key to be rejected")
+	}
+	if err = verifySignature(checksums, signature, "not a key"); err == nil {
+		t.Error("expected an invalid public key to be rejected")
+	}
+}
+
+func TestReleasePublicKey(t *testing.T) {
+	key, err := base64.StdEncoding.DecodeString(releasePublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		t.Errorf("releasePublicKey is not a base64 ed25519 public key: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const latestReleaseURL = "https://api.github.com/repos/s-things/wick/releases/latest"
+
+type versionInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+
+	LatestVersion   string `json:"latest_version,omitempty"`
+	UpdateAvailable bool   `json:"update_available,omitempty"`
+}
+
+type release struct {
+	TagName string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name        string `json:"name"`
+	DownloadURL string `json:"browser_download_url"`
+}
+
+func printVersion(asJSON bool, check bool) {
+	info := versionInfo{
+		Version:   versionString,
+		Commit:    gitCommit,
+		BuildDate: buildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if check {
+		latest, err := latestRelease()
+		if err != nil {
+			logrus.Fatal("Failed to check for updates: ", err)
+		}
+		info.LatestVersion = strings.TrimPrefix(latest.TagName, "v")
+		info.UpdateAvailable = newerVersion(info.LatestVersion, versionString)
+	}
+
+	if asJSON {
+		jsonString, err := json.MarshalIndent(info, "", "    ")
+		if err != nil {
+			logrus.Fatal(err)
+		}
+		fmt.Println(string(jsonString))
+		return
+	}
+
+	fmt.Printf("wick %s\n", info.Version)
+	fmt.Printf("commit:     %s\n", info.Commit)
+	fmt.Printf("build date: %s\n", info.BuildDate)
+	fmt.Printf("go version: %s\n", info.GoVersion)
+	fmt.Printf("platform:   %s\n", info.Platform)
+	if check {
+		if developmentBuild(versionString) {
+			fmt.Printf("This is a development build, the latest release is %s\n", info.LatestVersion)
+		} else if info.UpdateAvailable {
+			fmt.Printf("A newer version is available: %s\n", info.LatestVersion)
+		} else {
+			fmt.Println("wick is up to date")
+		}
+	}
+}
+
+func latestRelease() (*release, error) {
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	response, err := httpClient.Get(latestReleaseURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from GitHub: %s", response.Status)
+	}
+
+	var latest release
+	if err = json.NewDecoder(response.Body).Decode(&latest); err != nil {
+		return nil, err
+	}
+
+	return &latest, nil
+}
+
+// semver is a MAJOR.MINOR.PATCH[-PRERELEASE] version, see https://semver.org.
+type semver struct {
+	numbers    [3]int
+	prerelease []string
+}
+
+// parseVersion parses a semantic version, with or without a leading v. Build
+// metadata after + is ignored.
+func parseVersion(version string) (semver, bool) {
+	var v semver
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexByte(version, '+'); i >= 0 {
+		version = version[:i]
+	}
+	if i := strings.IndexByte(version, '-'); i >= 0 {
+		v.prerelease = strings.Split(version[i+1:], ".")
+		version = version[:i]
+	}
+
+	numbers := strings.Split(version, ".")
+	if len(numbers) != len(v.numbers) {
+		return v, false
+	}
+	for i, number := range numbers {
+		n, err := strconv.Atoi(number)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v.numbers[i] = n
+	}
+	for _, identifier := range v.prerelease {
+		if identifier == "" {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// compareVersions returns -1, 0 or 1 if a precedes, equals or follows b.
+func compareVersions(a semver, b semver) int {
+	for i := range a.numbers {
+		if c := compareInts(a.numbers[i], b.numbers[i]); c != 0 {
+			return c
+		}
+	}
+
+	// A pre-release precedes its release.
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := compareIdentifiers(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a.prerelease), len(b.prerelease))
+}
+
+// compareIdentifiers compares pre-release identifiers: numeric ones by
+// value and before alphanumeric ones, which compare in ASCII order.
+func compareIdentifiers(a string, b string) int {
+	aNumber, aErr := strconv.Atoi(a)
+	bNumber, bErr := strconv.Atoi(b)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compareInts(aNumber, bNumber)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a int, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// gitDescribeSuffix matches what git describe appends to a tag for commits
+// after it or for a dirty tree, e.g. 0.3.0-5-g1a2b3c4-dirty.
+var gitDescribeSuffix = regexp.MustCompile(`(-[0-9]+-g[0-9a-f]+)?(-dirty)?$`)
+
+// developmentBuild tells whether version is not a release: not semantic, the
+// 0.0.0-dev default of source builds, or a git describe of an untagged commit.
+func developmentBuild(version string) bool {
+	v, ok := parseVersion(version)
+	if !ok || v.numbers == [3]int{} {
+		return true
+	}
+	return gitDescribeSuffix.FindString(version) != ""
+}
+
+// newerVersion tells whether latest follows current. Development builds are
+// never outdated.
+func newerVersion(latest string, current string) bool {
+	if developmentBuild(current) {
+		return false
+	}
+	latestVersion, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	currentVersion, _ := parseVersion(current)
+	return compareVersions(latestVersion, currentVersion) > 0
+}
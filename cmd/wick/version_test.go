@@ -0,0 +1,57 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package main
+
+import "testing"
+
+func TestDevelopmentBuild(t *testing.T) {
+	for version, expected := range map[string]bool{
+		"0.3.0":                  false,
+		"v0.3.0":                 false,
+		"0.4.0-rc.1":             false,
+		"0.0.0-dev":              true,
+		"0.3.0-dirty":            true,
+		"0.3.0-5-g1a2b3c4":       true,
+		"0.3.0-5-g1a2b3c4-dirty": true,
+		"1a2b3c4":                true,
+		"":                       true,
+	} {
+		if got := developmentBuild(version); got != expected {
+			t.Errorf("developmentBuild(%q) = %v, want %v", version, got, expected)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	if !newerVersion("0.4.0", "0.3.0") {
+		t.Error("0.4.0 should be newer than 0.3.0")
+	}
+	if newerVersion("0.3.0", "0.3.0") {
+		t.Error("0.3.0 should not be newer than itself")
+	}
+	if newerVersion("0.4.0", "0.0.0-dev") {
+		t.Error("a development build should never be outdated")
+	}
+}
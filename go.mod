@@ -4,16 +4,23 @@ go 1.17
 
 require (
 	github.com/gammazero/nexus/v3 v3.0.3
+	github.com/itchyny/gojq v0.12.7
+	github.com/santhosh-tekuri/jsonschema/v5 v5.1.1
+	go.starlark.net v0.0.0-20231101134539-556fd59b42f6
 	golang.org/x/crypto v0.0.0-20201016220609-9e8e0b390897
+	golang.org/x/term v0.0.0-20220526004731-065cf7ba2467
 	gopkg.in/alecthomas/kingpin.v2 v2.2.6
 )
 
-require golang.org/x/sys v0.0.0-20220519141025-dcacdad47464 // indirect
+require (
+	github.com/itchyny/timefmt-go v0.1.3 // indirect
+	golang.org/x/sys v0.7.0 // indirect
+)
 
 require (
 	github.com/alecthomas/template v0.0.0-20190718012654-fb15b899a751 // indirect
 	github.com/alecthomas/units v0.0.0-20210927113745-59d0afb8317a // indirect
-	github.com/gorilla/websocket v1.4.2 // indirect
+	github.com/gorilla/websocket v1.4.2
 	github.com/sirupsen/logrus v1.8.1
-	github.com/ugorji/go/codec v1.1.13 // indirect
+	github.com/ugorji/go/codec v1.1.13
 )
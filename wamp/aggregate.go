@@ -0,0 +1,333 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/itchyny/gojq"
+)
+
+// Aggregation reduces the results of repeated calls to a summary: count,
+// or the sum, average or distinct values of a jq path into each result.
+type Aggregation struct {
+	kind string
+	path *gojq.Code
+
+	mu       sync.Mutex
+	calls    int
+	errors   int
+	values   int
+	skipped  int
+	sum      float64
+	distinct map[string]int
+}
+
+// AggregateSummary is printed after the calls.
+type AggregateSummary struct {
+	Calls  int `json:"calls"`
+	Errors int `json:"errors"`
+	// Values counts the results the path found a usable value in, Skipped
+	// the others.
+	Values   int            `json:"values,omitempty"`
+	Skipped  int            `json:"skipped,omitempty"`
+	Sum      *float64       `json:"sum,omitempty"`
+	Avg      *float64       `json:"avg,omitempty"`
+	Distinct map[string]int `json:"distinct,omitempty"`
+}
+
+// ParseAggregation parses count, sum:PATH, avg:PATH or distinct:PATH. PATH
+// is a jq expression over {"args": [...], "kwargs": {...}}, e.g.
+// ".args[0].latency" or ".kwargs.status".
+func ParseAggregation(expression string) (*Aggregation, error) {
+	kind, path := expression, ""
+	if colon := strings.Index(expression, ":"); colon >= 0 {
+		kind, path = expression[:colon], expression[colon+1:]
+	}
+
+	a := &Aggregation{kind: kind, distinct: map[string]int{}}
+	switch kind {
+	case "count":
+		if path != "" {
+			return nil, fmt.Errorf("invalid aggregate '%s': count takes no path", expression)
+		}
+		return a, nil
+	case "sum", "avg", "distinct":
+		if path == "" {
+			return nil, fmt.Errorf("invalid aggregate '%s': expected %s:PATH", expression, kind)
+		}
+	default:
+		return nil, fmt.Errorf("invalid aggregate '%s': expected count, sum:PATH, avg:PATH or distinct:PATH",
+			expression)
+	}
+
+	query, err := gojq.Parse(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid aggregate path '%s': %w", path, err)
+	}
+	if a.path, err = gojq.Compile(query); err != nil {
+		return nil, fmt.Errorf("invalid aggregate path '%s': %w", path, err)
+	}
+	return a, nil
+}
+
+// add counts the outcome of a call.
+func (a *Aggregation) add(result *wamp.Result, err error) {
+	var value interface{}
+	found := false
+	if err == nil && a.path != nil {
+		value, found = a.lookup(result)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls++
+	if err != nil {
+		a.errors++
+		return
+	}
+
+	switch a.kind {
+	case "sum", "avg":
+		number, ok := toFloat(value)
+		if !found || !ok {
+			a.skipped++
+			return
+		}
+		a.sum += number
+		a.values++
+	case "distinct":
+		if !found {
+			a.skipped++
+			return
+		}
+		key, ok := value.(string)
+		if !ok {
+			data, _ := json.Marshal(value)
+			key = string(data)
+		}
+		a.distinct[key]++
+		a.values++
+	}
+}
+
+// lookup returns the first value the path produces for result.
+func (a *Aggregation) lookup(result *wamp.Result) (interface{}, bool) {
+	args, kwargs := wamp.List{}, wamp.Dict{}
+	if result != nil {
+		if result.Arguments != nil {
+			args = result.Arguments
+		}
+		if result.ArgumentsKw != nil {
+			kwargs = result.ArgumentsKw
+		}
+	}
+
+	// gojq only handles plain JSON types, so normalize the result first.
+	data, err := json.Marshal(map[string]interface{}{"args": args, "kwargs": kwargs})
+	if err != nil {
+		return nil, false
+	}
+	var input interface{}
+	if err = json.Unmarshal(data, &input); err != nil {
+		return nil, false
+	}
+
+	value, ok := a.path.Run(input).Next()
+	if !ok || value == nil {
+		return nil, false
+	}
+	if _, isErr := value.(error); isErr {
+		return nil, false
+	}
+	return value, true
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case float64:
+		return number, true
+	case int:
+		return float64(number), true
+	}
+	return 0, false
+}
+
+// Summary returns the aggregate of the calls counted so far.
+func (a *Aggregation) Summary() AggregateSummary {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	summary := AggregateSummary{Calls: a.calls, Errors: a.errors, Values: a.values, Skipped: a.skipped}
+	switch a.kind {
+	case "sum":
+		sum := a.sum
+		summary.Sum = &sum
+	case "avg":
+		if a.values > 0 {
+			avg := a.sum / float64(a.values)
+			summary.Avg = &avg
+		}
+	case "distinct":
+		summary.Distinct = a.distinct
+	}
+	return summary
+}
+
+// CallRepeated calls procedure repeat times, at most parallel at a time,
+// evaluating templates in the procedure and arguments anew for every call.
+// The parallel callers are spread over sessions in turn, so a session's
+// round trips don't cap the rate of the calls. In the procedure, {{i}} is
+// the index of the call. The rows set with SetDataRows are sent in turn.
+// With ticks enabled, each call waits for a tick. Results are printed as
+// they arrive or, if aggregation is set, reduced by it and only its summary
+// is printed. Ctrl-C stops before the remaining calls.
+func CallRepeated(sessions []*client.Client, procedure string, args []string, kwargs map[string]string,
+	options map[string]string, repeat int, parallel int, aggregation *Aggregation) {
+	printEach := aggregation == nil
+	if printEach {
+		aggregation, _ = ParseAggregation("count")
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	var mu sync.Mutex
+	var firstErr error
+	// The history holds the payload of the first call, as templates make
+	// every call's different.
+	var sentArgs wamp.List
+	var sentKwargs wamp.Dict
+	recorded := false
+	bar := newProgressBar(repeat, "calls")
+	calls := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		session := sessions[i%len(sessions)]
+		go func() {
+			defer wg.Done()
+			for i := range calls {
+				Jitter()
+				start := time.Now()
+				arguments, keywordArguments, result, err := callOnce(session, expandIteration(procedure, i), args,
+					kwargs, options, dataRow(i))
+				recordMeasurement(start, arguments, keywordArguments, err)
+				aggregation.add(result, err)
+
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = err
+				}
+				if !recorded {
+					sentArgs, sentKwargs, recorded = arguments, keywordArguments, true
+				}
+				if printEach {
+					bar.print(func() { printResult(result, err) })
+				}
+				bar.add(err)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i := 0; i < repeat; i++ {
+		stopped, ended := nextTick(sigChan)
+		if !stopped && !ended {
+			select {
+			case calls <- i:
+				continue
+			case <-sigChan:
+				stopped = true
+			}
+		}
+		if stopped {
+			logger.Warnf("Interrupted, %d calls not made\n", repeat-i)
+		} else {
+			logger.Warnf("Ticks ended, %d calls not made\n", repeat-i)
+		}
+		break
+	}
+	close(calls)
+	wg.Wait()
+	bar.finish()
+	if recorded {
+		recordHistory("call", procedure, sentArgs, sentKwargs, firstErr)
+	}
+
+	summary := aggregation.Summary()
+	if printEach {
+		logger.Printf("Called procedure '%s' %d times, %d failed\n", procedure, summary.Calls, summary.Errors)
+		return
+	}
+	if firstErr != nil {
+		logger.Warn("First failed call: ", firstErr)
+	}
+	printJSON(summary)
+}
+
+// callOnce makes one of the calls of CallRepeated, with the payload of row
+// added, and returns the payload it sent along with the result.
+func callOnce(session *client.Client, procedure string, args []string, kwargs map[string]string,
+	options map[string]string, row *DataRow) (wamp.List, wamp.Dict, *wamp.Result, error) {
+	checkURI("call", procedure)
+	arguments, keywordArguments := withRow(listToWampList(args), DictToWampDict(kwargs), row)
+	if err := validatePayload(procedure, arguments, keywordArguments); err != nil {
+		return arguments, keywordArguments, nil, err
+	}
+	sealKwargs(keywordArguments)
+
+	callOptions := DictToWampDict(options)
+	addCorrelation(callOptions, keywordArguments)
+	result, err := session.Call(context.Background(), procedure, callOptions, arguments, keywordArguments, nil)
+	emitProgress(progressCalled, withURI(progressStatus(err), procedure))
+	if result != nil {
+		result.ArgumentsKw = unsealKwargs(result.ArgumentsKw)
+	}
+	return arguments, keywordArguments, result, err
+}
+
+// printResult prints the result of a call the way Call does, or its error.
+func printResult(result *wamp.Result, err error) {
+	if err != nil {
+		logger.Println(err)
+		return
+	}
+	if result == nil || printTransformed(result.Arguments, result.ArgumentsKw) {
+		return
+	}
+	if len(result.Arguments) > 0 {
+		printJSON(result.Arguments[0])
+	}
+}
@@ -0,0 +1,111 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// auditProfile is who a session joined the realm as.
+type auditProfile struct {
+	AuthID     string `json:"authid,omitempty"`
+	AuthRole   string `json:"authrole,omitempty"`
+	AuthMethod string `json:"authmethod,omitempty"`
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time        string       `json:"time"`
+	URL         string       `json:"url"`
+	Realm       string       `json:"realm"`
+	Profile     auditProfile `json:"profile"`
+	Operation   string       `json:"operation"`
+	URI         string       `json:"uri"`
+	PayloadHash string       `json:"payload_sha256,omitempty"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+}
+
+var audit struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// EnableAudit appends a JSON line to the file at path for every call,
+// publish, subscribe and register sent to a router.
+func EnableAudit(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	audit.file = file
+	return nil
+}
+
+func auditEnabled() bool {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	return audit.file != nil
+}
+
+// payloadHash returns the SHA-256 of the payload, so the audit log never
+// holds the payload itself.
+func payloadHash(args wamp.List, kwargs wamp.Dict) string {
+	if len(args) == 0 && len(kwargs) == 0 {
+		return ""
+	}
+	payload, _ := json.Marshal(wamp.List{args, kwargs})
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+// writeAudit appends record to the audit log, if enabled.
+func writeAudit(record auditRecord) {
+	audit.mu.Lock()
+	defer audit.mu.Unlock()
+	if audit.file == nil {
+		return
+	}
+
+	record.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	line, _ := json.Marshal(record)
+	if _, err := audit.file.Write(append(line, '\n')); err != nil {
+		logger.Warn("Failed to write audit log: ", err)
+	}
+}
@@ -0,0 +1,273 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// rateLimiter spaces out operations so that at most rate happen per second.
+// A rate of zero or less disables limiting.
+type rateLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	limiter := &rateLimiter{}
+	if rate > 0 {
+		limiter.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return limiter
+}
+
+// wait blocks until the next operation is allowed.
+func (l *rateLimiter) wait() {
+	if l.interval == 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	delay := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mu.Unlock()
+
+	time.Sleep(delay)
+}
+
+// rewriteURI replaces the longest matching prefix from rewrites in uri.
+func rewriteURI(uri string, rewrites map[string]string) string {
+	longest := ""
+	for prefix := range rewrites {
+		if strings.HasPrefix(uri, prefix) && len(prefix) > len(longest) {
+			longest = prefix
+		}
+	}
+	if longest == "" {
+		return uri
+	}
+	return rewrites[longest] + strings.TrimPrefix(uri, longest)
+}
+
+// bridgeQueue is set by SetBridgeQueue, nil otherwise.
+var bridgeQueue *diskQueue
+
+// SetBridgeQueue makes Bridge queue events in a file in dir and forward them
+// from there, in order, reconnecting to the destination router when it
+// goes away instead of exiting. Events queued but not forwarded when wick
+// exits are forwarded by the next Bridge with the same dir. An event may be
+// forwarded twice if wick exits right after forwarding it.
+func SetBridgeQueue(dir string) error {
+	queue, err := openDiskQueue(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open queue in '%s': %w", dir, err)
+	}
+	bridgeQueue = queue
+	return nil
+}
+
+// Bridge subscribes to topics on the source session and republishes every
+// event on the destination session. Each of procedures is registered on the
+// destination session, forwarding calls (including progressive results and
+// cancellation) to the source session. This goes on until CTRL-c or one of
+// the sessions goes away; with SetBridgeQueue, only the source session. Topics
+// and procedures are renamed on the destination by replacing the longest
+// matching prefix from rewrites, and at most rate events per second are
+// forwarded.
+func Bridge(source *client.Client, destination *client.Client, topics []string, match string,
+	procedures []string, rewrites map[string]string, rate float64) {
+
+	limiter := newRateLimiter(rate)
+	register, unregister, destinationDone := destination.Register, destination.Unregister, destination.Done()
+	if bridgeQueue != nil {
+		r, err := NewReconnectingSession(destination, DefaultMaxReconnectBackoff)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		defer r.Close()
+		register, unregister, destinationDone = r.Register, r.Unregister, r.Done()
+		if pending, err := bridgeQueue.pending(); err == nil && pending > 0 {
+			logger.Printf("Forwarding %d queued events first\n", pending)
+		}
+		go forwardQueued(r, limiter)
+	}
+
+	for _, topic := range topics {
+		subscribedTopic := topic
+		eventHandler := func(event *wamp.Event) {
+			// For pattern-based subscriptions the router tells the concrete topic.
+			eventTopic := subscribedTopic
+			if detail, ok := wamp.AsString(event.Details["topic"]); ok {
+				eventTopic = detail
+			}
+			destinationTopic := rewriteURI(eventTopic, rewrites)
+			if bridgeQueue != nil {
+				if err := bridgeQueue.push(destinationTopic, event.Arguments, event.ArgumentsKw); err != nil {
+					logger.Errorf("Failed to queue event from '%s': %s\n", eventTopic, err)
+				}
+				return
+			}
+
+			limiter.wait()
+			publication, err := publishAcknowledged(destination, destinationTopic, nil, event.Arguments,
+				event.ArgumentsKw)
+			if err != nil {
+				logger.Printf("Failed to forward event from '%s' to '%s': %s\n", eventTopic, destinationTopic, err)
+			} else {
+				logger.Debugf("Forwarded event from '%s' to '%s' with publication id %d\n", eventTopic,
+					destinationTopic, publication)
+			}
+		}
+
+		options := wamp.Dict{wamp.OptMatch: match}
+		if err := source.Subscribe(topic, eventHandler, options); err != nil {
+			logger.Fatal("subscribe error:", err)
+		}
+		logger.Printf("Forwarding events from topic '%s'\n", topic)
+	}
+
+	for _, procedure := range procedures {
+		destinationProcedure := rewriteURI(procedure, rewrites)
+		if err := register(destinationProcedure, proxyHandler(source, destination, procedure), nil); err != nil {
+			logger.Fatal("Failed to register procedure:", err)
+		}
+		logger.Printf("Forwarding calls of '%s' to '%s'\n", destinationProcedure, procedure)
+	}
+
+	// Wait for CTRL-c or either session to close while forwarding events.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	select {
+	case <-sigChan:
+	case <-source.Done():
+		logger.Print("Source router gone, exiting")
+		return
+	case <-destinationDone:
+		logger.Print("Destination router gone, exiting")
+	}
+
+	for _, topic := range topics {
+		if err := source.Unsubscribe(topic); err != nil {
+			logger.Println("Failed to unsubscribe:", err)
+		}
+	}
+	for _, procedure := range procedures {
+		if err := unregister(rewriteURI(procedure, rewrites)); err != nil {
+			logger.Println("Failed to unregister procedure:", err)
+		}
+	}
+}
+
+// proxyHandler returns an invocation handler that calls procedure on the
+// source session and relays its (progressive) results back to the caller on
+// the destination session. Canceling the invocation cancels the forwarded call.
+func proxyHandler(source *client.Client, destination *client.Client, procedure string) client.InvocationHandler {
+	return func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		var options wamp.Dict
+		var progress client.ProgressHandler
+		if receiveProgress, _ := wamp.AsBool(inv.Details[wamp.OptReceiveProgress]); receiveProgress {
+			options = wamp.Dict{wamp.OptReceiveProgress: true}
+			progress = func(result *wamp.Result) {
+				// The destination may have reconnected since the call.
+				if err := liveSession(destination).SendProgress(ctx, result.Arguments, result.ArgumentsKw); err != nil {
+					logger.Println("Failed to forward progressive result:", err)
+				}
+			}
+		}
+
+		result, err := source.Call(ctx, procedure, options, inv.Arguments, inv.ArgumentsKw, progress)
+		if err != nil {
+			if rpcErr, ok := err.(client.RPCError); ok {
+				return client.InvokeResult{Err: rpcErr.Err.Error, Args: rpcErr.Err.Arguments,
+					Kwargs: rpcErr.Err.ArgumentsKw}
+			}
+			if ctx.Err() != nil {
+				return client.InvocationCanceled
+			}
+			return client.InvokeResult{Err: errRuntime, Args: wamp.List{err.Error()}}
+		}
+
+		return client.InvokeResult{Args: result.Arguments, Kwargs: result.ArgumentsKw}
+	}
+}
+
+// forwardQueued publishes the events of the bridge queue on the current
+// session of destination, in order, until it is closed. While the
+// destination router is down, events wait in the queue for it to be back.
+func forwardQueued(destination *ReconnectingSession, limiter *rateLimiter) {
+	for {
+		event, next, ok, err := bridgeQueue.peek()
+		if err != nil {
+			logger.Error(err)
+			if err = bridgeQueue.ack(next); err != nil {
+				logger.Fatal("Failed to update queue: ", err)
+			}
+			continue
+		}
+		if !ok {
+			if !bridgeQueue.wait(destination.Done()) {
+				return
+			}
+			continue
+		}
+
+		limiter.wait()
+		session := destination.Client()
+		publication, err := publishAcknowledged(session, event.Topic, nil, event.Args, event.Kwargs)
+		if err != nil && !session.Connected() {
+			// Keep the event until the destination reconnected.
+			for destination.Client() == session {
+				select {
+				case <-destination.Done():
+					return
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+			continue
+		}
+		if err != nil {
+			logger.Printf("Failed to forward event to '%s': %s\n", event.Topic, err)
+		} else {
+			logger.Debugf("Forwarded event to '%s' with publication id %d\n", event.Topic, publication)
+		}
+		if err = bridgeQueue.ack(next); err != nil {
+			logger.Fatal("Failed to update queue: ", err)
+		}
+	}
+}
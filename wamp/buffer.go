@@ -0,0 +1,106 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Policies applied when the event buffer of a subscription is full.
+const (
+	OverflowDropOldest = "drop-oldest"
+	OverflowBlock      = "block"
+	OverflowExit       = "exit"
+)
+
+// bufferedEvent is an event together with the topic it was published to.
+type bufferedEvent struct {
+	topic string
+	event *wamp.Event
+}
+
+// eventBuffer is a bounded FIFO of events between the session, which
+// receives them, and a slower handler, which consumes them.
+type eventBuffer struct {
+	mu       sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	events   []bufferedEvent
+	size     int
+	policy   string
+	dropped  uint64
+}
+
+func newEventBuffer(size int, policy string) *eventBuffer {
+	b := &eventBuffer{size: size, policy: policy}
+	b.notEmpty = sync.NewCond(&b.mu)
+	b.notFull = sync.NewCond(&b.mu)
+	return b
+}
+
+// push adds an event to the buffer, applying the overflow policy if the
+// buffer is full.
+func (b *eventBuffer) push(topic string, event *wamp.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.events) >= b.size {
+		switch b.policy {
+		case OverflowBlock:
+			b.notFull.Wait()
+			continue
+		case OverflowExit:
+			logger.Fatalf("Event buffer full (%d events), exiting", b.size)
+		default:
+			b.events[0] = bufferedEvent{}
+			b.events = b.events[1:]
+			b.dropped++
+			if b.dropped == 1 || b.dropped%1000 == 0 {
+				logger.Warnf("Event buffer full, dropped %d events so far", b.dropped)
+			}
+		}
+	}
+
+	b.events = append(b.events, bufferedEvent{topic, event})
+	b.notEmpty.Signal()
+}
+
+// pop removes and returns the oldest event and its topic, waiting until one
+// is available.
+func (b *eventBuffer) pop() (string, *wamp.Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.events) == 0 {
+		b.notEmpty.Wait()
+	}
+	buffered := b.events[0]
+	b.events[0] = bufferedEvent{}
+	b.events = b.events[1:]
+	b.notFull.Signal()
+	return buffered.topic, buffered.event
+}
@@ -0,0 +1,143 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// cachedResult is a call result in the cache directory.
+type cachedResult struct {
+	Time      time.Time `json:"time"`
+	Procedure string    `json:"procedure"`
+	Args      wamp.List `json:"args,omitempty"`
+	Kwargs    wamp.Dict `json:"kwargs,omitempty"`
+}
+
+var cache struct {
+	dir    string
+	ttl    time.Duration
+	bypass bool
+	url    string
+	realm  string
+}
+
+// EnableCache serves the results of calls made in the last ttl against url
+// and realm from files in dir instead of calling the router again. With
+// bypass the router is always called, but the cache is still refreshed.
+func EnableCache(dir string, ttl time.Duration, bypass bool, url string, realm string) {
+	cache.dir = dir
+	cache.ttl = ttl
+	cache.bypass = bypass
+	cache.url = url
+	cache.realm = realm
+}
+
+// cacheKey names the cache file of a call by hashing the router, the
+// principal the session joined as, the procedure, the call options and the
+// payload, so results are never served to another principal or for other
+// options.
+func cacheKey(session *client.Client, procedure string, options wamp.Dict, args wamp.List,
+	kwargs wamp.Dict) string {
+	details := session.RealmDetails()
+	// Maps are marshaled with sorted keys, so equal payloads hash the same.
+	payload, _ := json.Marshal(wamp.List{cache.url, cache.realm, details["authid"], details["authrole"],
+		details["authmethod"], procedure, options, args, kwargs})
+	hash := sha256.Sum256(payload)
+	return hex.EncodeToString(hash[:])
+}
+
+// cachedCall returns the cached result of calling procedure with the payload
+// hashed to key, if caching is enabled and the result has not expired.
+func cachedCall(procedure string, key string) (*wamp.Result, bool) {
+	if cache.ttl <= 0 || cache.bypass {
+		return nil, false
+	}
+
+	path := filepath.Join(cache.dir, key)
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedResult
+	if err = json.Unmarshal(data, &cached); err != nil || cached.Procedure != procedure {
+		return nil, false
+	}
+	age := time.Since(cached.Time)
+	if age > cache.ttl || age < 0 {
+		os.Remove(path)
+		return nil, false
+	}
+	logger.Debugf("Serving result of '%s' cached %s ago", procedure, age.Round(time.Millisecond))
+	return &wamp.Result{Arguments: cached.Args, ArgumentsKw: cached.Kwargs}, true
+}
+
+// cacheResult stores the result of calling procedure with the payload hashed
+// to key, if caching is enabled.
+func cacheResult(procedure string, key string, result *wamp.Result) {
+	if cache.ttl <= 0 || result == nil {
+		return
+	}
+
+	data, err := json.Marshal(cachedResult{Time: time.Now(), Procedure: procedure, Args: result.Arguments,
+		Kwargs: result.ArgumentsKw})
+	if err == nil {
+		err = writeCacheFile(filepath.Join(cache.dir, key), data)
+	}
+	if err != nil {
+		logger.Warn("Failed to cache result: ", err)
+	}
+}
+
+// writeCacheFile replaces the file at path with data, so concurrent wicks
+// never read a partial result.
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	file, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
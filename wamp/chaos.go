@@ -0,0 +1,206 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// chaosConfig describes the faults injected into wick's own transport.
+type chaosConfig struct {
+	// disconnectRate is the chance per minute of dropping the connection.
+	disconnectRate float64
+	latency        time.Duration
+	jitter         time.Duration
+}
+
+var chaos *chaosConfig
+
+// SetChaos injects faults into the transport of every session opened
+// afterwards, to test how routers and backends deal with flaky clients.
+// spec is a comma separated list of faults:
+//
+//	disconnect:5%/min      drop the connection with a 5% chance every minute
+//	latency:200ms±100ms    delay every message sent and received by 100-300ms
+//
+// The jitter may also be written as +-.
+func SetChaos(spec string) error {
+	config := &chaosConfig{}
+	for _, fault := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(fault), ":", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid chaos fault '%s': expected name:value", fault)
+		}
+		var err error
+		switch kv[0] {
+		case "disconnect":
+			config.disconnectRate, err = parseRatePerMinute(kv[1])
+		case "latency":
+			config.latency, config.jitter, err = parseLatency(kv[1])
+		default:
+			err = fmt.Errorf("unknown chaos fault '%s'", kv[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	chaos = config
+	return nil
+}
+
+// parseRatePerMinute parses a chance per minute such as "5%/min".
+func parseRatePerMinute(value string) (float64, error) {
+	percent := strings.TrimSuffix(strings.TrimSuffix(value, "/min"), "%")
+	rate, err := strconv.ParseFloat(percent, 64)
+	if err != nil || rate < 0 || rate > 100 {
+		return 0, fmt.Errorf("invalid disconnect rate '%s': expected e.g. 5%%/min", value)
+	}
+	return rate / 100, nil
+}
+
+// parseLatency parses a latency with optional jitter such as "200ms±100ms".
+func parseLatency(value string) (time.Duration, time.Duration, error) {
+	parts := strings.SplitN(strings.Replace(value, "+-", "±", 1), "±", 2)
+	latency, err := time.ParseDuration(parts[0])
+	if err != nil || latency < 0 {
+		return 0, 0, fmt.Errorf("invalid latency '%s': expected e.g. 200ms±100ms", value)
+	}
+	var jitter time.Duration
+	if len(parts) == 2 {
+		if jitter, err = time.ParseDuration(parts[1]); err != nil || jitter < 0 || jitter > latency {
+			return 0, 0, fmt.Errorf("invalid latency jitter '%s': must not exceed the latency", parts[1])
+		}
+	}
+	return latency, jitter, nil
+}
+
+// delay returns a latency drawn uniformly from latency±jitter.
+func (c *chaosConfig) delay() time.Duration {
+	if c.jitter == 0 {
+		return c.latency
+	}
+	return c.latency - c.jitter + time.Duration(rand.Int63n(int64(2*c.jitter)+1))
+}
+
+// chaosPeer wraps the peer of a session to delay messages and drop the
+// connection at random.
+type chaosPeer struct {
+	wamp.Peer
+	config *chaosConfig
+	rd     chan wamp.Message
+
+	// sendMu keeps delayed messages in order.
+	sendMu    sync.Mutex
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newChaosPeer(peer wamp.Peer, config *chaosConfig) *chaosPeer {
+	p := &chaosPeer{
+		Peer:   peer,
+		config: config,
+		rd:     make(chan wamp.Message),
+		done:   make(chan struct{}),
+	}
+	go p.recvHandler()
+	if config.disconnectRate > 0 {
+		go p.disconnectHandler()
+	}
+	return p
+}
+
+func (p *chaosPeer) recvHandler() {
+	defer close(p.rd)
+	for msg := range p.Peer.Recv() {
+		p.sleep()
+		p.rd <- msg
+	}
+}
+
+// disconnectHandler drops the connection with the configured chance per
+// minute, deciding once a second.
+func (p *chaosPeer) disconnectHandler() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if rand.Float64() < p.config.disconnectRate/60 {
+				logger.Warn("Chaos: dropping the connection")
+				p.Close()
+				return
+			}
+		case <-p.done:
+			return
+		}
+	}
+}
+
+func (p *chaosPeer) sleep() {
+	if delay := p.config.delay(); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-p.done:
+		}
+	}
+}
+
+func (p *chaosPeer) Recv() <-chan wamp.Message { return p.rd }
+
+func (p *chaosPeer) Send(msg wamp.Message) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.sleep()
+	return p.Peer.Send(msg)
+}
+
+func (p *chaosPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.sleep()
+	return p.Peer.SendCtx(ctx, msg)
+}
+
+func (p *chaosPeer) TrySend(msg wamp.Message) error {
+	p.sendMu.Lock()
+	defer p.sendMu.Unlock()
+	p.sleep()
+	return p.Peer.TrySend(msg)
+}
+
+func (p *chaosPeer) Close() {
+	p.closeOnce.Do(func() {
+		close(p.done)
+		p.Peer.Close()
+	})
+}
@@ -0,0 +1,272 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/ugorji/go/codec"
+)
+
+// codecHandle returns the codec of a binary serialization, configured like
+// the nexus serializers.
+func codecHandle(serialization serialize.Serialization) (codec.Handle, error) {
+	mapType := reflect.TypeOf(map[string]interface{}(nil))
+	switch serialization {
+	case serialize.MSGPACK:
+		handle := &codec.MsgpackHandle{WriteExt: true}
+		handle.MapType = mapType
+		return handle, nil
+	case serialize.CBOR:
+		handle := &codec.CborHandle{}
+		handle.MapType = mapType
+		return handle, nil
+	}
+	return nil, fmt.Errorf("serialization %v has no binary codec", serialization)
+}
+
+// ReadPayload reads a captured payload from the file at source or, if there
+// is no such file, from source as hex. Whitespace, colons and a 0x prefix
+// in hex are ignored, as in dumps from tcpdump or router logs.
+func ReadPayload(source string) ([]byte, error) {
+	if data, err := os.ReadFile(source); err == nil {
+		return data, nil
+	}
+
+	cleaned := strings.TrimPrefix(strings.TrimSpace(source), "0x")
+	cleaned = strings.NewReplacer(" ", "", "\n", "", "\t", "", ":", "").Replace(cleaned)
+	data, err := hex.DecodeString(cleaned)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is neither a readable file nor hex: %w", source, err)
+	}
+	return data, nil
+}
+
+// Decode decodes a serialized WAMP message or payload and prints it as
+// JSON, naming the message type if it is a message. A rawsocket frame
+// header in front of the payload is skipped.
+func Decode(serialization serialize.Serialization, data []byte) error {
+	if len(data) >= 4 && data[0]&0x7 == rawSocketMessage &&
+		int(data[1])<<16|int(data[2])<<8|int(data[3]) == len(data)-4 {
+		logger.Debug("Skipping rawsocket frame header")
+		data = data[4:]
+	}
+
+	var value interface{}
+	if serialization == serialize.JSON {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&value); err != nil {
+			return fmt.Errorf("failed to decode json: %w", err)
+		}
+	} else {
+		handle, err := codecHandle(serialization)
+		if err != nil {
+			return err
+		}
+		if err = codec.NewDecoderBytes(data, handle).Decode(&value); err != nil {
+			return fmt.Errorf("failed to decode payload: %w", err)
+		}
+	}
+
+	if name := messageTypeName(value); name != "" {
+		printLabel(name + ":")
+	}
+	printJSON(value)
+	return nil
+}
+
+// messageFields are the kinds of the fields that follow the type of each
+// WAMP message, required and optional: i for an integer, s for a string, d
+// for a dict and l for a list.
+var messageFields = map[wamp.MessageType][2]string{
+	wamp.HELLO:        {"sd", ""},
+	wamp.WELCOME:      {"id", ""},
+	wamp.ABORT:        {"ds", ""},
+	wamp.CHALLENGE:    {"sd", ""},
+	wamp.AUTHENTICATE: {"sd", ""},
+	wamp.GOODBYE:      {"ds", ""},
+	wamp.ERROR:        {"iids", "ld"},
+	wamp.PUBLISH:      {"ids", "ld"},
+	wamp.PUBLISHED:    {"ii", ""},
+	wamp.SUBSCRIBE:    {"ids", ""},
+	wamp.SUBSCRIBED:   {"ii", ""},
+	wamp.UNSUBSCRIBE:  {"ii", ""},
+	wamp.UNSUBSCRIBED: {"i", ""},
+	wamp.EVENT:        {"iid", "ld"},
+	wamp.CALL:         {"ids", "ld"},
+	wamp.CANCEL:       {"id", ""},
+	wamp.RESULT:       {"id", "ld"},
+	wamp.REGISTER:     {"ids", ""},
+	wamp.REGISTERED:   {"ii", ""},
+	wamp.UNREGISTER:   {"ii", ""},
+	wamp.UNREGISTERED: {"i", ""},
+	wamp.INVOCATION:   {"iid", "ld"},
+	wamp.INTERRUPT:    {"id", ""},
+	wamp.YIELD:        {"id", "ld"},
+}
+
+// messageTypeName returns the name of the WAMP message type value encodes,
+// or "" if it is not a message, such as a payload list like [1, 2] that
+// merely starts with a message type.
+func messageTypeName(value interface{}) string {
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 || fieldKind(list[0]) != 'i' {
+		return ""
+	}
+	messageType, _ := asInteger(list[0])
+	if messageType <= 0 || messageType > 255 {
+		return ""
+	}
+	fields, ok := messageFields[wamp.MessageType(messageType)]
+	if !ok {
+		return ""
+	}
+	required, optional := fields[0], fields[1]
+	rest := list[1:]
+	if len(rest) < len(required) || len(rest) > len(required)+len(optional) {
+		return ""
+	}
+	kinds := required + optional
+	for i, field := range rest {
+		if fieldKind(field) != kinds[i] {
+			return ""
+		}
+	}
+	return wamp.MessageType(messageType).String()
+}
+
+// fieldKind returns the kind of a decoded message field as in
+// messageFields, or 0 for any other value.
+func fieldKind(field interface{}) byte {
+	switch field.(type) {
+	case string, []byte:
+		return 's'
+	case map[string]interface{}, map[interface{}]interface{}:
+		return 'd'
+	case []interface{}:
+		return 'l'
+	}
+	if _, ok := asInteger(field); ok {
+		return 'i'
+	}
+	return 0
+}
+
+// asInteger returns the integer value of a decoded number.
+func asInteger(value interface{}) (int64, bool) {
+	if number, ok := value.(json.Number); ok {
+		i, err := number.Int64()
+		return i, err == nil
+	}
+	return wamp.AsInt64(value)
+}
+
+// Output formats of Encode.
+const (
+	EncodeHex    = "hex"
+	EncodeBase64 = "base64"
+	EncodeRaw    = "raw"
+)
+
+// Encode serializes the JSON value, e.g. a whole message such as
+// [16, 1, {}, "com.example.topic", [1, 2]] or just a payload, and writes the
+// bytes to output in format. With frame, a rawsocket frame header is added.
+func Encode(serialization serialize.Serialization, value string, format string, frame bool,
+	output io.Writer) error {
+	decoder := json.NewDecoder(strings.NewReader(value))
+	decoder.UseNumber()
+	var decoded interface{}
+	if err := decoder.Decode(&decoded); err != nil {
+		return fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var data []byte
+	if serialization == serialize.JSON {
+		var err error
+		if data, err = json.Marshal(decoded); err != nil {
+			return err
+		}
+	} else {
+		handle, err := codecHandle(serialization)
+		if err != nil {
+			return err
+		}
+		if err = codec.NewEncoderBytes(&data, handle).Encode(fromJSONNumbers(decoded)); err != nil {
+			return fmt.Errorf("failed to encode: %w", err)
+		}
+	}
+
+	if frame {
+		if len(data) >= MaxMaxMsgSize {
+			return fmt.Errorf("%d bytes do not fit in a rawsocket frame", len(data))
+		}
+		length := len(data)
+		data = append([]byte{rawSocketMessage, byte(length >> 16), byte(length >> 8), byte(length)}, data...)
+	}
+
+	var err error
+	switch format {
+	case EncodeHex:
+		_, err = fmt.Fprintln(output, hex.EncodeToString(data))
+	case EncodeBase64:
+		_, err = fmt.Fprintln(output, base64.StdEncoding.EncodeToString(data))
+	default:
+		_, err = output.Write(data)
+	}
+	return err
+}
+
+// fromJSONNumbers replaces the json.Numbers in value with integers or
+// floats, so binary serializers encode them as numbers.
+func fromJSONNumbers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = fromJSONNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = fromJSONNumbers(v[key])
+		}
+	}
+	return value
+}
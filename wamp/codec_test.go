@@ -0,0 +1,98 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/ugorji/go/codec"
+)
+
+func TestMessageTypeName(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{`[1, "realm1", {}]`, "HELLO"},
+		{`[16, 1, {}, "com.example.topic", [1, 2]]`, "PUBLISH"},
+		{`[36, 1, 2, {}, [], {"a": 1}]`, "EVENT"},
+		{`[1, 2]`, ""},
+		{`[16, 1, {}, 2]`, ""},
+		{`[50, 1, {}, [], {}, 3]`, ""},
+		{`[99, 1]`, ""},
+		{`{"a": 1}`, ""},
+	}
+	for _, test := range tests {
+		decoder := json.NewDecoder(strings.NewReader(test.value))
+		decoder.UseNumber()
+		var value interface{}
+		if err := decoder.Decode(&value); err != nil {
+			t.Fatal(err)
+		}
+		if name := messageTypeName(value); name != test.expected {
+			t.Errorf("%s: got %q, expected %q", test.value, name, test.expected)
+		}
+	}
+
+	// Binary serializers decode numbers and strings differently from JSON.
+	handle, err := codecHandle(serialize.MSGPACK)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	if err = codec.NewEncoderBytes(&data, handle).Encode([]interface{}{48, 7, map[string]interface{}{},
+		"com.example.add"}); err != nil {
+		t.Fatal(err)
+	}
+	var value interface{}
+	if err = codec.NewDecoderBytes(data, handle).Decode(&value); err != nil {
+		t.Fatal(err)
+	}
+	if name := messageTypeName(value); name != "CALL" {
+		t.Errorf("got %q for msgpack, expected CALL", name)
+	}
+}
+
+func TestEncodeFrameLimit(t *testing.T) {
+	// A JSON string of 16 MB, quotes included, doesn't fit in a frame.
+	value := `"` + strings.Repeat("a", MaxMaxMsgSize-2) + `"`
+	if err := Encode(serialize.JSON, value, EncodeRaw, true, io.Discard); err == nil {
+		t.Error("expected a 16 MB message to be rejected")
+	}
+
+	var output bytes.Buffer
+	value = `"` + strings.Repeat("a", MaxMaxMsgSize-3) + `"`
+	if err := Encode(serialize.JSON, value, EncodeRaw, true, &output); err != nil {
+		t.Fatal(err)
+	}
+	if header := output.Bytes()[:4]; !bytes.Equal(header, []byte{rawSocketMessage, 0xff, 0xff, 0xff}) {
+		t.Errorf("got frame header %x", header)
+	}
+}
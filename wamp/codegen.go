@@ -0,0 +1,242 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// procedureDescription describes a registration found through the meta API.
+type procedureDescription struct {
+	URI    string                 `json:"uri"`
+	Match  string                 `json:"match"`
+	Invoke string                 `json:"invoke,omitempty"`
+	Schema map[string]interface{} `json:"schema,omitempty"`
+
+	// Used by the Go template only.
+	FuncName string          `json:"-"`
+	Params   []stubParameter `json:"-"`
+}
+
+type stubParameter struct {
+	Name string
+	Type string
+}
+
+var stubTemplate = template.Must(template.New("stubs").Parse(`// Code generated by wick codegen. DO NOT EDIT.
+
+package {{ .Package }}
+
+import (
+	"context"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+{{ range .Procedures }}
+// {{ .FuncName }} calls '{{ .URI }}'.
+func {{ .FuncName }}(ctx context.Context, session *client.Client,
+	{{- range .Params }} {{ .Name }} {{ .Type }},{{ end }}
+	{{- if .Params }} kwargs wamp.Dict{{ else }} args wamp.List, kwargs wamp.Dict{{ end }}) (*wamp.Result, error) {
+	return session.Call(ctx, "{{ .URI }}", nil, {{ if .Params }}wamp.List{ {{- range $i, $p := .Params }}{{ if $i }}, {{ end }}{{ $p.Name }}{{ end -}} }{{ else }}args{{ end }}, kwargs, nil)
+}
+{{ end }}`))
+
+// Codegen lists the procedures registered on the realm through the meta API
+// and writes either Go client stubs (format "go") or a JSON descriptor
+// (format "json") to output. If schemaDir contains a JSON schema for a
+// procedure (see EnableValidation), it is used to type the stub parameters
+// and is included in the descriptor. Meta procedures are skipped.
+func Codegen(session *client.Client, codegenFormat string, packageName string, schemaDir string,
+	output io.Writer) {
+
+	procedures, err := listProcedures(session)
+	if err != nil {
+		logger.Fatal("Failed to list registrations: ", err)
+	}
+
+	for _, procedure := range procedures {
+		if schemaDir != "" {
+			procedure.Schema = readSchema(filepath.Join(schemaDir, procedure.URI+".json"))
+		}
+	}
+
+	if codegenFormat == "json" {
+		jsonString, err := json.MarshalIndent(map[string]interface{}{"procedures": procedures}, "", "    ")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		fmt.Fprintln(output, string(jsonString))
+		return
+	}
+
+	// Only exact registrations have a fixed URI that can be wrapped.
+	var stubs []*procedureDescription
+	funcNames := map[string]bool{}
+	for _, procedure := range procedures {
+		if procedure.Match != wamp.MatchExact {
+			continue
+		}
+		procedure.FuncName = uniqueIdentifier(goIdentifier(procedure.URI), funcNames)
+		if procedure.FuncName != goIdentifier(procedure.URI) {
+			logger.Warnf("Generating %s for '%s', as %s is taken\n", procedure.FuncName, procedure.URI,
+				goIdentifier(procedure.URI))
+		}
+		procedure.Params = schemaParameters(procedure.Schema)
+		stubs = append(stubs, procedure)
+	}
+
+	var source bytes.Buffer
+	err = stubTemplate.Execute(&source, map[string]interface{}{
+		"Package":    packageName,
+		"Procedures": stubs,
+	})
+	if err != nil {
+		logger.Fatal(err)
+	}
+	formatted, err := format.Source(source.Bytes())
+	if err != nil {
+		logger.Fatal("Failed to format generated code: ", err)
+	}
+	if _, err = output.Write(formatted); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+func listProcedures(session *client.Client) ([]*procedureDescription, error) {
+	ids, err := matchIDs(session, wamp.MetaProcRegList)
+	if err != nil {
+		return nil, err
+	}
+
+	var procedures []*procedureDescription
+	for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+		metaGetEach(session, wamp.MetaProcRegGet, ids[match], func(id wamp.ID, details wamp.Dict) bool {
+			uri, _ := wamp.AsString(details["uri"])
+			if !strings.HasPrefix(uri, "wamp.") {
+				invoke, _ := wamp.AsString(details["invoke"])
+				procedures = append(procedures, &procedureDescription{URI: uri, Match: match, Invoke: invoke})
+			}
+			return true
+		})
+	}
+
+	sort.Slice(procedures, func(i, j int) bool {
+		return procedures[i].URI < procedures[j].URI
+	})
+	return procedures, nil
+}
+
+func readSchema(path string) map[string]interface{} {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var schema map[string]interface{}
+	if err = json.Unmarshal(data, &schema); err != nil {
+		logger.Printf("Ignoring invalid schema %s: %s\n", path, err)
+		return nil
+	}
+	return schema
+}
+
+// schemaParameters derives typed positional parameters from the "args"
+// property of a payload schema, if it lists them with prefixItems.
+func schemaParameters(schema map[string]interface{}) []stubParameter {
+	properties, _ := wamp.AsDict(schema["properties"])
+	argsSchema, _ := wamp.AsDict(properties["args"])
+	items, _ := wamp.AsList(argsSchema["prefixItems"])
+
+	var params []stubParameter
+	for i, item := range items {
+		itemSchema, _ := wamp.AsDict(item)
+		itemType, _ := wamp.AsString(itemSchema["type"])
+
+		goType := "interface{}"
+		switch itemType {
+		case "integer":
+			goType = "int64"
+		case "number":
+			goType = "float64"
+		case "string":
+			goType = "string"
+		case "boolean":
+			goType = "bool"
+		case "array":
+			goType = "[]interface{}"
+		case "object":
+			goType = "map[string]interface{}"
+		}
+		params = append(params, stubParameter{Name: fmt.Sprintf("arg%d", i), Type: goType})
+	}
+	return params
+}
+
+// goIdentifier turns a URI such as com.example.get_user into an exported Go
+// identifier such as ComExampleGetUser.
+func goIdentifier(uri string) string {
+	var builder strings.Builder
+	upper := true
+	for _, r := range uri {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if builder.Len() == 0 && unicode.IsDigit(r) {
+			builder.WriteString("Proc")
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// uniqueIdentifier returns identifier, or identifier with the first number
+// from 2 that makes it unique, since URIs such as com.foo_bar and com.foo.bar
+// map to the same identifier. The result is added to taken.
+func uniqueIdentifier(identifier string, taken map[string]bool) string {
+	unique := identifier
+	for i := 2; taken[unique]; i++ {
+		unique = fmt.Sprintf("%s%d", identifier, i)
+	}
+	taken[unique] = true
+	return unique
+}
@@ -0,0 +1,192 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Serializers lists the serializers wick supports, by name.
+var Serializers = []struct {
+	Name          string
+	Serialization serialize.Serialization
+	serializer    serialize.Serializer
+}{
+	{"json", serialize.JSON, &serialize.JSONSerializer{}},
+	{"msgpack", serialize.MSGPACK, &serialize.MessagePackSerializer{}},
+	{"cbor", serialize.CBOR, &serialize.CBORSerializer{}},
+}
+
+// comparePayload is the payload of the standard workload, mixing the types
+// commonly found in application payloads.
+func comparePayload() (wamp.List, wamp.Dict) {
+	values := make(wamp.List, 32)
+	for i := range values {
+		values[i] = i * 1000
+	}
+	args := wamp.List{42, 3.14159, "hello, world", true, values}
+	kwargs := wamp.Dict{
+		"name":    "wick",
+		"enabled": false,
+		"ratio":   0.25,
+		"tags":    wamp.List{"alpha", "beta", "gamma"},
+		"nested":  wamp.Dict{"id": 123456789, "label": "nested object"},
+	}
+	return args, kwargs
+}
+
+// RunMetadata describes a run in reports, so results can be compared and
+// reproduced later.
+type RunMetadata struct {
+	Version string
+	URL     string
+	Realm   string
+	Seed    int64
+}
+
+// write writes the metadata of a run from start to end as aligned lines.
+func (m RunMetadata) write(w io.Writer, start, end time.Time) {
+	host, _ := os.Hostname()
+	fmt.Fprintf(w, "version:\t%s\n", m.Version)
+	fmt.Fprintf(w, "seed:\t%d\n", m.Seed)
+	fmt.Fprintf(w, "url:\t%s\n", m.URL)
+	fmt.Fprintf(w, "realm:\t%s\n", m.Realm)
+	fmt.Fprintf(w, "host:\t%s (%s/%s)\n", host, runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(w, "start:\t%s\n", start.UTC().Format(time.RFC3339))
+	fmt.Fprintf(w, "end:\t%s\n", end.UTC().Format(time.RFC3339))
+}
+
+// latencies summarizes round-trip times.
+type latencies []time.Duration
+
+func (l latencies) percentile(p float64) time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	sorted := append(latencies(nil), l...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func (l latencies) mean() time.Duration {
+	if len(l) == 0 {
+		return 0
+	}
+	var total time.Duration
+	for _, d := range l {
+		total += d
+	}
+	return total / time.Duration(len(l))
+}
+
+// CompareSerializers joins once per serializer using connect, runs the
+// same call and publish workload on each session and writes the payload
+// sizes and round-trip latencies per serializer to output, followed by the
+// metadata of the run.
+func CompareSerializers(connect func(serialize.Serialization) *client.Client, iterations int,
+	metadata RunMetadata, output io.Writer) {
+	start := time.Now()
+	args, kwargs := comparePayload()
+	suffix := time.Now().UnixNano()
+	procedure := fmt.Sprintf("wick.compare_serializers.echo%d", suffix)
+	topic := fmt.Sprintf("wick.compare_serializers.event%d", suffix)
+
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERIALIZER\tCALL BYTES\tCALL MEAN\tCALL P50\tCALL P99\tPUBLISH BYTES\tPUBLISH MEAN\tPUBLISH P50\tPUBLISH P99")
+
+	for _, s := range Serializers {
+		callBytes, err := s.serializer.Serialize(&wamp.Call{Request: 1, Options: wamp.Dict{},
+			Procedure: wamp.URI(procedure), Arguments: args, ArgumentsKw: kwargs})
+		if err != nil {
+			logger.Fatalf("Failed to serialize with %s: %s", s.Name, err)
+		}
+		publishBytes, err := s.serializer.Serialize(&wamp.Publish{Request: 1,
+			Options: wamp.Dict{wamp.OptAcknowledge: true}, Topic: wamp.URI(topic), Arguments: args,
+			ArgumentsKw: kwargs})
+		if err != nil {
+			logger.Fatalf("Failed to serialize with %s: %s", s.Name, err)
+		}
+
+		logger.Debugf("Running workload with %s\n", s.Name)
+		session := connect(s.Serialization)
+		calls, publishes := runCompareWorkload(session, procedure, topic, args, kwargs, iterations)
+		session.Close()
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%d\t%s\t%s\t%s\n", s.Name,
+			len(callBytes), calls.mean(), calls.percentile(0.5), calls.percentile(0.99),
+			len(publishBytes), publishes.mean(), publishes.percentile(0.5), publishes.percentile(0.99))
+	}
+	w.Flush()
+
+	fmt.Fprintln(output)
+	w = tabwriter.NewWriter(output, 0, 4, 1, ' ', 0)
+	metadata.write(w, start, time.Now())
+	w.Flush()
+}
+
+// runCompareWorkload registers an echo procedure on session and calls it,
+// then publishes with acknowledgement, iterations times each.
+func runCompareWorkload(session *client.Client, procedure, topic string, args wamp.List, kwargs wamp.Dict,
+	iterations int) (latencies, latencies) {
+
+	echo := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		return client.InvokeResult{Args: inv.Arguments, Kwargs: inv.ArgumentsKw}
+	}
+	if err := session.Register(procedure, echo, nil); err != nil {
+		logger.Fatal("Failed to register procedure:", err)
+	}
+	defer session.Unregister(procedure)
+
+	calls := make(latencies, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		if _, err := session.Call(context.Background(), procedure, nil, args, kwargs, nil); err != nil {
+			logger.Fatal("Call error: ", err)
+		}
+		calls = append(calls, time.Since(start))
+	}
+
+	publishes := make(latencies, 0, iterations)
+	for i := 0; i < iterations; i++ {
+		start := time.Now()
+		if _, err := publishAcknowledged(session, topic, nil, args, kwargs); err != nil {
+			logger.Fatal("Publish error: ", err)
+		}
+		publishes = append(publishes, time.Since(start))
+	}
+
+	return calls, publishes
+}
@@ -0,0 +1,71 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// optCorrelationID is the call and publish option carrying the correlation
+// id, as used by WAMP tracing.
+const optCorrelationID = "correlation_id"
+
+var correlationID, correlationKwarg string
+
+// SetCorrelationID makes every call and publish carry id as the
+// correlation_id option, and additionally as the kwarg named kwarg if that is
+// not empty. The id is echoed in the logs so operations can be traced.
+func SetCorrelationID(id string, kwarg string) {
+	correlationID = id
+	correlationKwarg = kwarg
+}
+
+// NewCorrelationID returns a random (version 4) UUID.
+func NewCorrelationID() string {
+	var uuid [16]byte
+	if _, err := rand.Read(uuid[:]); err != nil {
+		logger.Fatal(err)
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:])
+}
+
+// addCorrelation adds the correlation id to options and kwargs and returns a
+// suffix for log messages.
+func addCorrelation(options wamp.Dict, kwargs wamp.Dict) string {
+	if correlationID == "" {
+		return ""
+	}
+
+	options[optCorrelationID] = correlationID
+	if correlationKwarg != "" && kwargs != nil {
+		kwargs[correlationKwarg] = correlationID
+	}
+	return fmt.Sprintf(" (correlation id %s)", correlationID)
+}
@@ -0,0 +1,160 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DataRow is the payload of one repeated call or publish, read from a data
+// file.
+type DataRow struct {
+	Args   wamp.List `json:"args"`
+	Kwargs wamp.Dict `json:"kwargs"`
+}
+
+var dataRows []DataRow
+
+// SetDataRows makes repeated calls and publishes send rows in turn. The args
+// of a row follow those given on the command line, and its kwargs are added
+// to them.
+func SetDataRows(rows []DataRow) {
+	dataRows = rows
+}
+
+// dataRow returns the row of the iteration-th repeated operation, or nil if
+// there are no rows.
+func dataRow(iteration int) *DataRow {
+	if len(dataRows) == 0 {
+		return nil
+	}
+	return &dataRows[iteration%len(dataRows)]
+}
+
+// withRow adds the payload of row, if any, to arguments and
+// keywordArguments.
+func withRow(arguments wamp.List, keywordArguments wamp.Dict, row *DataRow) (wamp.List, wamp.Dict) {
+	if row == nil {
+		return arguments, keywordArguments
+	}
+	arguments = append(arguments, row.Args...)
+	for key, value := range row.Kwargs {
+		keywordArguments[key] = value
+	}
+	return arguments, keywordArguments
+}
+
+// ReadDataCSV reads rows from the CSV file at path. The first line names the
+// columns: columns named by a number are positional args at that index,
+// counting from 0, and the others are kwargs. Values are typed like command
+// line arguments, without expanding templates, and empty kwargs are left
+// out.
+func ReadDataCSV(path string) ([]DataRow, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(records) < 2 {
+		return nil, fmt.Errorf("%s: expected a header line and at least one row", path)
+	}
+
+	header := records[0]
+	positions := map[int]int{}
+	for column, name := range header {
+		if index, err := strconv.Atoi(name); err == nil {
+			if index < 0 || index >= len(header) {
+				return nil, fmt.Errorf("%s: argument index %d out of range", path, index)
+			}
+			if _, ok := positions[index]; ok {
+				return nil, fmt.Errorf("%s: argument %d given twice", path, index)
+			}
+			positions[index] = column
+		}
+	}
+	for index := range positions {
+		if _, ok := positions[index-1]; index > 0 && !ok {
+			return nil, fmt.Errorf("%s: argument %d missing", path, index-1)
+		}
+	}
+
+	rows := make([]DataRow, 0, len(records)-1)
+	for _, record := range records[1:] {
+		row := DataRow{Args: make(wamp.List, len(positions)), Kwargs: wamp.Dict{}}
+		for column, value := range record {
+			typed, _ := inferValue(value)
+			if index, err := strconv.Atoi(header[column]); err == nil {
+				row.Args[index] = typed
+			} else if value != "" {
+				row.Kwargs[header[column]] = typed
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// ReadDataDir reads a row from every .json file in dir, in the order of
+// their names. Each holds an object with args and kwargs, both optional.
+func ReadDataDir(dir string) ([]DataRow, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no .json files in %s", dir)
+	}
+	sort.Strings(paths)
+
+	rows := make([]DataRow, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var row DataRow
+		if err = json.Unmarshal(data, &row); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if row.Kwargs == nil {
+			row.Kwargs = wamp.Dict{}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
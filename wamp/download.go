@@ -0,0 +1,120 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const progressBarWidth = 30
+
+// resultFile writes call results to a file instead of printing them. Each
+// progressive result is appended as it arrives, so large results can be
+// streamed to disk in chunks.
+type resultFile struct {
+	path    string
+	file    *os.File
+	written int
+	chunks  int
+	// total is the expected size in bytes, taken from a "total" or "size"
+	// kwarg of the first result that has one.
+	total    int
+	terminal bool
+}
+
+func newResultFile(path string) (*resultFile, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &resultFile{path: path, file: file, terminal: isTerminal(os.Stderr)}, nil
+}
+
+// write appends the first argument of result to the file. Strings and
+// binary values are written as is, anything else as JSON.
+func (r *resultFile) write(result *wamp.Result) error {
+	if r.total == 0 {
+		for _, key := range []string{"total", "size"} {
+			if total, ok := wamp.AsInt64(result.ArgumentsKw[key]); ok {
+				r.total = int(total)
+				break
+			}
+		}
+	}
+	if len(result.Arguments) == 0 {
+		return nil
+	}
+
+	var data []byte
+	switch value := result.Arguments[0].(type) {
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		var err error
+		if data, err = json.Marshal(value); err != nil {
+			return err
+		}
+	}
+	n, err := r.file.Write(data)
+	r.written += n
+	r.chunks++
+	r.render()
+	return err
+}
+
+// render draws a progress bar on stderr when it is a terminal.
+func (r *resultFile) render() {
+	if !r.terminal {
+		return
+	}
+	if r.total <= 0 {
+		fmt.Fprintf(os.Stderr, "\r%s written (%d chunks)", formatBytes(r.written), r.chunks)
+		return
+	}
+	done := r.written * progressBarWidth / r.total
+	if done > progressBarWidth {
+		done = progressBarWidth
+	}
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %s / %s", strings.Repeat("=", done),
+		strings.Repeat(" ", progressBarWidth-done), formatBytes(r.written), formatBytes(r.total))
+}
+
+func (r *resultFile) close() error {
+	if r.terminal && r.chunks > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	logger.Printf("Wrote %s in %d chunks to %s\n", formatBytes(r.written), r.chunks, r.path)
+	return nil
+}
@@ -0,0 +1,71 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+var eventCallProcedure string
+
+// EnableEventCall makes Subscribe call procedure for every event it
+// receives, with the event's args and kwargs, and print the result like a
+// received event. With a transform, the values it produces from the event
+// are the args of the call, as they are the payload of teed events, and the
+// result is transformed before it is printed.
+func EnableEventCall(procedure string) {
+	eventCallProcedure = procedure
+}
+
+// eventCall calls the event call procedure for event, received on topic,
+// if enabled.
+func eventCall(session *client.Client, topic string, event *wamp.Event) {
+	if eventCallProcedure == "" {
+		return
+	}
+
+	args, kwargs := event.Arguments, event.ArgumentsKw
+	if transform != nil {
+		values, err := transformPayload(args, kwargs)
+		if err != nil {
+			logger.Printf("Failed to transform event for '%s': %s\n", eventCallProcedure, err)
+			return
+		}
+		args, kwargs = wamp.List(values), nil
+	}
+
+	result, err := session.Call(context.Background(), eventCallProcedure, nil, args, kwargs, nil)
+	emitProgress(progressCalled, withURI(progressStatus(err), eventCallProcedure))
+	if err != nil {
+		logger.Printf("Call to '%s' for event on '%s' failed: %s\n", eventCallProcedure, topic, err)
+		return
+	}
+
+	logger.Printf("Called '%s' for event on '%s'\n", eventCallProcedure, topic)
+	argsKWArgs(result.Arguments, result.ArgumentsKw, nil)
+}
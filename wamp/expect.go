@@ -0,0 +1,171 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Expectation describes the outcome a call is expected to have. Empty fields
+// are not checked.
+type Expectation struct {
+	// Args is the JSON list of expected result arguments.
+	Args string
+	// Kwargs is the JSON object of expected result keyword arguments.
+	Kwargs string
+	// Error is the URI of the error the call is expected to fail with.
+	Error string
+}
+
+func (e Expectation) isSet() bool {
+	return e.Args != "" || e.Kwargs != "" || e.Error != ""
+}
+
+// parse decodes the expected args and kwargs.
+func (e Expectation) parse() (args interface{}, kwargs interface{}, err error) {
+	if e.Args != "" {
+		if err = json.Unmarshal([]byte(e.Args), &args); err != nil {
+			return nil, nil, fmt.Errorf("invalid expected args: %v", err)
+		}
+	}
+	if e.Kwargs != "" {
+		if err = json.Unmarshal([]byte(e.Kwargs), &kwargs); err != nil {
+			return nil, nil, fmt.Errorf("invalid expected kwargs: %v", err)
+		}
+	}
+	return args, kwargs, nil
+}
+
+// check compares the outcome of a call with the expectation and returns a
+// report of the differences, or an empty string if it matched.
+func (e Expectation) check(result *wamp.Result, callErr error) (string, error) {
+	expectedArgs, expectedKwargs, err := e.parse()
+	if err != nil {
+		return "", err
+	}
+
+	var rpcErr client.RPCError
+	isRPCErr := errors.As(callErr, &rpcErr)
+
+	if e.Error != "" {
+		switch {
+		case callErr == nil:
+			return fmt.Sprintf("expected error %s, but the call succeeded", e.Error), nil
+		case !isRPCErr:
+			return fmt.Sprintf("expected error %s, got: %v", e.Error, callErr), nil
+		case string(rpcErr.Err.Error) != e.Error:
+			return fmt.Sprintf("expected error %s, got %s", e.Error, rpcErr.Err.Error), nil
+		}
+		// The error payload is compared against the expected args and kwargs.
+		result = &wamp.Result{Arguments: rpcErr.Err.Arguments, ArgumentsKw: rpcErr.Err.ArgumentsKw}
+	} else if callErr != nil {
+		return fmt.Sprintf("call failed: %v", callErr), nil
+	}
+
+	var report []string
+	if e.Args != "" {
+		if diff := jsonDiff(expectedArgs, result.Arguments, wamp.List{}); diff != "" {
+			report = append(report, "args:\n"+diff)
+		}
+	}
+	if e.Kwargs != "" {
+		if diff := jsonDiff(expectedKwargs, result.ArgumentsKw, wamp.Dict{}); diff != "" {
+			report = append(report, "kwargs:\n"+diff)
+		}
+	}
+	return strings.Join(report, "\n"), nil
+}
+
+// jsonDiff compares expected with actual as JSON values and returns a line
+// diff of their indented encodings if they differ. A nil actual is replaced
+// by empty.
+func jsonDiff(expected interface{}, actual interface{}, empty interface{}) string {
+	if reflect.ValueOf(actual).IsNil() {
+		actual = empty
+	}
+
+	// Round trip actual through JSON so both sides have the same types.
+	data, err := json.Marshal(actual)
+	if err != nil {
+		return err.Error()
+	}
+	var normalized interface{}
+	if err = json.Unmarshal(data, &normalized); err != nil {
+		return err.Error()
+	}
+	if reflect.DeepEqual(expected, normalized) {
+		return ""
+	}
+
+	expectedJSON, _ := json.MarshalIndent(expected, "", "    ")
+	actualJSON, _ := json.MarshalIndent(normalized, "", "    ")
+	return lineDiff(strings.Split(string(expectedJSON), "\n"), strings.Split(string(actualJSON), "\n"))
+}
+
+// lineDiff returns a diff of the lines of a and b, marking lines only in a
+// with "-" and lines only in b with "+".
+func lineDiff(a []string, b []string) string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var builder strings.Builder
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			builder.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			builder.WriteString("- " + a[i] + "\n")
+			i++
+		default:
+			builder.WriteString("+ " + b[j] + "\n")
+			j++
+		}
+	}
+	return strings.TrimRight(builder.String(), "\n")
+}
@@ -0,0 +1,63 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gammazero/nexus/v3/client"
+)
+
+// ServeHealth starts an HTTP server on addr with liveness (/healthz) and
+// readiness (/readyz) endpoints, for running long-lived wick processes under
+// an orchestrator such as Kubernetes. /readyz reports 503 once the session
+// has left the router.
+func ServeHealth(addr string, session *client.Client) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealth(w, http.StatusOK, "alive")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if liveSession(session).Connected() {
+			writeHealth(w, http.StatusOK, "joined")
+		} else {
+			writeHealth(w, http.StatusServiceUnavailable, "failed")
+		}
+	})
+
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Fatal("health server error: ", err)
+		}
+	}()
+	logger.Printf("Serving health checks on %s\n", addr)
+}
+
+func writeHealth(w http.ResponseWriter, status int, state string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]string{"state": state})
+}
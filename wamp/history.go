@@ -0,0 +1,243 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DefaultHistorySize is how many operations are kept in the history by
+// default.
+const DefaultHistorySize = 100
+
+const (
+	// historyLockTimeout is how long to wait for another wick process to
+	// finish updating the history.
+	historyLockTimeout = 2 * time.Second
+	// staleHistoryLock is the age of a lock left over by a wick process that
+	// died while holding it.
+	staleHistoryLock = 10 * time.Second
+)
+
+// HistoryEntry is a call or publish in the history.
+type HistoryEntry struct {
+	ID        int       `json:"id"`
+	Time      string    `json:"time"`
+	URL       string    `json:"url"`
+	Realm     string    `json:"realm"`
+	Command   []string  `json:"command"`
+	Operation string    `json:"operation"`
+	URI       string    `json:"uri"`
+	Args      wamp.List `json:"args,omitempty"`
+	Kwargs    wamp.Dict `json:"kwargs,omitempty"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var history struct {
+	mu      sync.Mutex
+	path    string
+	size    int
+	command []string
+	url     string
+	realm   string
+}
+
+// EnableHistory keeps the last size calls and publishes in the file at path,
+// along with command, the arguments wick was run with, so they can be run
+// again.
+func EnableHistory(path string, size int, command []string, url string, realm string) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	history.path = path
+	history.size = size
+	history.command = command
+	history.url = url
+	history.realm = realm
+}
+
+// ReadHistory returns the entries of the history file at path, oldest
+// first. A missing file is an empty history.
+func ReadHistory(path string) ([]HistoryEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []HistoryEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 16*1024*1024)
+	for scanner.Scan() {
+		var entry HistoryEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// Skip lines of a file written by a newer wick, or cut off.
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// recordHistory adds the outcome of the call or publish wick was run for to
+// the history, if enabled. Entries are appended, and the file is trimmed to
+// its size once it holds twice as many.
+func recordHistory(operation string, uri string, args wamp.List, kwargs wamp.Dict, err error) {
+	history.mu.Lock()
+	defer history.mu.Unlock()
+	if history.path == "" || history.size <= 0 {
+		return
+	}
+
+	if mkdirErr := os.MkdirAll(filepath.Dir(history.path), 0700); mkdirErr != nil {
+		logger.Warn("Failed to write history: ", mkdirErr)
+		return
+	}
+	unlock, lockErr := lockHistory(history.path)
+	if lockErr != nil {
+		logger.Warn("Failed to write history: ", lockErr)
+		return
+	}
+	defer unlock()
+
+	entries, readErr := ReadHistory(history.path)
+	if readErr != nil {
+		logger.Warn("Failed to read history: ", readErr)
+		return
+	}
+	entry := HistoryEntry{
+		ID:        1,
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		URL:       history.url,
+		Realm:     history.realm,
+		Command:   history.command,
+		Operation: operation,
+		URI:       uri,
+		Args:      args,
+		Kwargs:    kwargs,
+		Status:    "ok",
+	}
+	if len(entries) > 0 {
+		entry.ID = entries[len(entries)-1].ID + 1
+	}
+	if err != nil {
+		entry.Status = "error"
+		entry.Error = err.Error()
+	}
+
+	entries = append(entries, entry)
+	var writeErr error
+	if len(entries) >= 2*history.size {
+		writeErr = writeHistory(history.path, entries[len(entries)-history.size:])
+	} else {
+		writeErr = appendHistory(history.path, entry)
+	}
+	if writeErr != nil {
+		logger.Warn("Failed to write history: ", writeErr)
+	}
+}
+
+// LastHistoryEntries returns the last size entries of entries.
+func LastHistoryEntries(entries []HistoryEntry, size int) []HistoryEntry {
+	if size > 0 && len(entries) > size {
+		return entries[len(entries)-size:]
+	}
+	return entries
+}
+
+// lockHistory takes the lock file of the history at path, so that wick
+// processes running at once don't lose each other's entries. It returns
+// the function releasing the lock.
+func lockHistory(path string) (func(), error) {
+	lock := path + ".lock"
+	deadline := time.Now().Add(historyLockTimeout)
+	for {
+		file, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(lock); statErr == nil && time.Since(info.ModTime()) > staleHistoryLock {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, errors.New("history is locked by another wick process")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// appendHistory adds entry to the end of the history file at path.
+func appendHistory(path string, entry HistoryEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// writeHistory replaces the history file at path with entries, through a
+// temporary file of this process renamed over it.
+func writeHistory(path string, entries []HistoryEntry) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(file)
+	for _, entry := range entries {
+		if err = encoder.Encode(entry); err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return err
+		}
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,80 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	EnableHistory(path, 3, []string{"call", "foo"}, "ws://localhost:8080/ws", "realm1")
+	defer EnableHistory("", 0, nil, "", "")
+
+	for i := 0; i < 10; i++ {
+		recordHistory("call", "foo", nil, nil, nil)
+	}
+
+	entries, err := ReadHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) < 3 || len(entries) >= 6 {
+		t.Errorf("expected the history to be trimmed, it has %d entries", len(entries))
+	}
+	last := LastHistoryEntries(entries, 3)
+	for i, entry := range last {
+		if entry.ID != 8+i {
+			t.Errorf("entry %d has id %d, expected %d", i, entry.ID, 8+i)
+		}
+	}
+	if _, err = os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("the history lock was not released")
+	}
+	temps, _ := filepath.Glob(path + ".*.tmp")
+	if len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
+
+func TestLockHistoryStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	if err := os.WriteFile(path+".lock", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * staleHistoryLock)
+	if err := os.Chtimes(path+".lock", stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	unlock, err := lockHistory(path)
+	if err != nil {
+		t.Fatal("expected a stale lock to be taken over: ", err)
+	}
+	unlock()
+}
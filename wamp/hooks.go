@@ -0,0 +1,78 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"github.com/gammazero/nexus/v3/client"
+)
+
+// SessionHooks are called on lifecycle changes of the sessions wick opens,
+// so applications embedding wick can react to them instead of polling
+// client.Done(). Any of them may be nil.
+type SessionHooks struct {
+	// OnJoin is called after a session joined its realm.
+	OnJoin func(session *client.Client)
+	// OnLeave is called after a session left its realm or lost its
+	// connection to the router.
+	OnLeave func(session *client.Client)
+	// OnError is called when connecting or joining fails, before wick
+	// exits.
+	OnError func(err error)
+	// OnReconnect is called with the new session after a ReconnectingSession
+	// reconnected and subscribed and registered again, after OnJoin.
+	OnReconnect func(session *client.Client)
+}
+
+var hooks SessionHooks
+
+// SetSessionHooks sets the hooks called for every session opened
+// afterwards.
+func SetSessionHooks(sessionHooks SessionHooks) {
+	hooks = sessionHooks
+}
+
+func notifyJoin(session *client.Client) {
+	if hooks.OnJoin != nil {
+		hooks.OnJoin(session)
+	}
+	if onLeave := hooks.OnLeave; onLeave != nil {
+		go func() {
+			<-session.Done()
+			onLeave(session)
+		}()
+	}
+}
+
+func notifyError(err error) {
+	if hooks.OnError != nil {
+		hooks.OnError(err)
+	}
+}
+
+func notifyReconnect(session *client.Client) {
+	if hooks.OnReconnect != nil {
+		hooks.OnReconnect(session)
+	}
+}
@@ -0,0 +1,76 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var jitter struct {
+	min time.Duration
+	max time.Duration
+}
+
+// SetJitter makes repeated operations and scenario instance joins wait a
+// random time first, so they don't reach the router in lockstep. spec is the
+// range of the wait, e.g. "0..100ms" or "20ms..50ms", or only its upper
+// bound, e.g. "100ms".
+func SetJitter(spec string) error {
+	minSpec, maxSpec := "0s", spec
+	if dots := strings.Index(spec, ".."); dots >= 0 {
+		minSpec, maxSpec = spec[:dots], spec[dots+2:]
+		// The unit may be given only once, as in 10..100ms.
+		if _, err := strconv.ParseFloat(minSpec, 64); err == nil {
+			minSpec += strings.TrimLeft(maxSpec, "0123456789.")
+		}
+	}
+
+	min, err := time.ParseDuration(minSpec)
+	if err != nil {
+		return fmt.Errorf("invalid jitter '%s': %w", spec, err)
+	}
+	max, err := time.ParseDuration(maxSpec)
+	if err != nil {
+		return fmt.Errorf("invalid jitter '%s': %w", spec, err)
+	}
+	if min < 0 || max < min {
+		return fmt.Errorf("invalid jitter '%s': expected 0 <= MIN <= MAX", spec)
+	}
+
+	jitter.min, jitter.max = min, max
+	return nil
+}
+
+// Jitter waits a random time in the range set with SetJitter, if any.
+func Jitter() {
+	if jitter.max <= 0 {
+		return
+	}
+	time.Sleep(jitter.min + time.Duration(rand.Int63n(int64(jitter.max-jitter.min)+1)))
+}
@@ -0,0 +1,286 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// progressKilled is the progress event of every session killed by KillPlan.
+const progressKilled = "session_killed"
+
+// KillFilter selects the sessions to kill. Empty fields match any session.
+type KillFilter struct {
+	Authrole string
+	Authid   string
+	// IdleLongerThan only selects sessions that held no registrations or
+	// subscriptions for this long. Routers do not report when a session was
+	// last active, so wick watches the realm for that long to find them.
+	// Calls and publishes of other sessions are not visible to it.
+	IdleLongerThan time.Duration
+}
+
+func (f KillFilter) matches(s sessionInfo) bool {
+	return (f.Authrole == "" || s.detail("authrole") == f.Authrole) &&
+		(f.Authid == "" || s.detail("authid") == f.Authid)
+}
+
+// KillPlan is the set of sessions to kill found by PlanKill.
+type KillPlan struct {
+	sessions []sessionInfo
+}
+
+// Len returns how many sessions the plan kills.
+func (p *KillPlan) Len() int {
+	return len(p.sessions)
+}
+
+// Write writes the sessions the plan kills as a table to output.
+func (p *KillPlan) Write(output io.Writer) {
+	writeSessions(output, p.sessions, -1, "\n")
+}
+
+// PlanKill finds the sessions on the realm, except wick's own, that match
+// filter.
+func PlanKill(session *client.Client, filter KillFilter) (*KillPlan, error) {
+	var watcher *idleWatcher
+	if filter.IdleLongerThan > 0 {
+		// Watch before listing, so nothing that happens in between is missed.
+		var err error
+		if watcher, err = watchIdle(session); err != nil {
+			return nil, err
+		}
+		defer watcher.stop()
+	}
+
+	plan := &KillPlan{}
+	err := eachSession(session, "", func(s sessionInfo) {
+		if filter.matches(s) {
+			plan.sessions = append(plan.sessions, s)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	if watcher == nil || len(plan.sessions) == 0 {
+		return plan, nil
+	}
+
+	if err = watcher.markAttached(session); err != nil {
+		return nil, err
+	}
+	logger.Printf("Watching %d sessions for %s to find idle ones\n", len(plan.sessions), filter.IdleLongerThan)
+	select {
+	case <-time.After(filter.IdleLongerThan):
+	case <-session.Done():
+		return nil, errors.New("router gone while watching sessions")
+	}
+
+	idle := plan.sessions[:0]
+	for _, s := range plan.sessions {
+		if watcher.isIdle(s.id) {
+			idle = append(idle, s)
+		}
+	}
+	plan.sessions = idle
+	return plan, nil
+}
+
+// idleWatcher records the sessions that hold or take registrations or
+// subscriptions, or leave, while it watches.
+type idleWatcher struct {
+	session *client.Client
+	mu      sync.Mutex
+	busy    map[wamp.ID]bool
+	topics  []string
+}
+
+func watchIdle(session *client.Client) (*idleWatcher, error) {
+	w := &idleWatcher{session: session, busy: map[wamp.ID]bool{}}
+	for _, topic := range []wamp.URI{wamp.MetaEventRegOnRegister, wamp.MetaEventSubOnSubscribe,
+		wamp.MetaEventSessionOnLeave} {
+		handler := func(event *wamp.Event) {
+			if len(event.Arguments) > 0 {
+				id, _ := wamp.AsID(event.Arguments[0])
+				w.markBusy(id)
+			}
+		}
+		if err := session.Subscribe(string(topic), handler, nil); err != nil {
+			w.stop()
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", topic, err)
+		}
+		w.topics = append(w.topics, string(topic))
+	}
+	return w, nil
+}
+
+func (w *idleWatcher) markBusy(id wamp.ID) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.busy[id] = true
+}
+
+func (w *idleWatcher) isIdle(id wamp.ID) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return !w.busy[id]
+}
+
+// markAttached marks the sessions that already hold registrations or
+// subscriptions as busy.
+func (w *idleWatcher) markAttached(session *client.Client) error {
+	for _, meta := range []struct{ list, attached wamp.URI }{
+		{wamp.MetaProcRegList, wamp.MetaProcRegListCallees},
+		{wamp.MetaProcSubList, wamp.MetaProcSubListSubscribers},
+	} {
+		ids, err := matchIDs(session, meta.list)
+		if err != nil {
+			return fmt.Errorf("%s: %w", meta.list, err)
+		}
+		attached := meta.attached
+		lookup := func(ctx context.Context, id wamp.ID) (interface{}, error) {
+			result, err := session.Call(ctx, string(attached), nil, wamp.List{id}, nil, nil)
+			if err != nil || len(result.Arguments) == 0 {
+				return wamp.List{}, err
+			}
+			sessionIDs, _ := wamp.AsList(result.Arguments[0])
+			return sessionIDs, nil
+		}
+		for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+			lookupEach(ids[match], lookup, func(_ wamp.ID, result interface{}) bool {
+				for _, value := range result.(wamp.List) {
+					id, _ := wamp.AsID(value)
+					w.markBusy(id)
+				}
+				return true
+			})
+		}
+	}
+	return nil
+}
+
+func (w *idleWatcher) stop() {
+	for _, topic := range w.topics {
+		_ = w.session.Unsubscribe(topic)
+	}
+}
+
+// KillResult counts what Execute did.
+type KillResult struct {
+	Killed int
+	// Gone counts sessions that left before they were killed.
+	Gone   int
+	Failed int
+	// Remaining counts sessions not tried because Execute was interrupted.
+	Remaining int
+}
+
+// rateInterval is the time between operations at rate per second. It is at
+// least a nanosecond, as time.NewTicker panics on a zero interval.
+func rateInterval(rate float64) time.Duration {
+	interval := time.Duration(float64(time.Second) / rate)
+	if interval < time.Nanosecond {
+		return time.Nanosecond
+	}
+	return interval
+}
+
+// Execute kills the sessions of the plan, at most rate per second, trying
+// each up to retries more times when killing it fails. Sessions that left
+// meanwhile are counted as gone. Interrupting with Ctrl-C stops after the
+// session being killed, so it is safe to cancel half way; running the same
+// filter again picks up the rest.
+func (p *KillPlan) Execute(session *client.Client, rate float64, retries int) KillResult {
+	result := KillResult{Remaining: len(p.sessions)}
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	ticker := time.NewTicker(rateInterval(rate))
+	defer ticker.Stop()
+	bar := newProgressBar(len(p.sessions), "sessions")
+	defer bar.finish()
+
+	for i, s := range p.sessions {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-sigChan:
+				logger.Warnf("Interrupted, %d sessions not killed\n", result.Remaining)
+				return result
+			}
+		}
+
+		err := killSessionRetrying(session, s.id, retries)
+		result.Remaining--
+		var rpcErr client.RPCError
+		switch {
+		case err == nil:
+			result.Killed++
+		case errors.As(err, &rpcErr) && rpcErr.Err.Error == wamp.ErrNoSuchSession:
+			result.Gone++
+			err = nil
+		default:
+			result.Failed++
+			logger.Errorf("Failed to kill session %d (%s): %s\n", s.id, s.detail("authid"), err)
+		}
+		fields := progressStatus(err)
+		fields["session"] = s.id
+		fields["authid"] = s.detail("authid")
+		emitProgress(progressKilled, fields)
+		bar.add(err)
+	}
+	return result
+}
+
+func killSessionRetrying(session *client.Client, id wamp.ID, retries int) error {
+	var err error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 500 * time.Millisecond)
+		}
+		err = killSession(session, id)
+		var rpcErr client.RPCError
+		if err == nil || (errors.As(err, &rpcErr) && rpcErr.Err.Error == wamp.ErrNoSuchSession) {
+			return err
+		}
+		select {
+		case <-session.Done():
+			return err
+		default:
+		}
+	}
+	return err
+}
@@ -0,0 +1,52 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import "github.com/sirupsen/logrus"
+
+// labelHook adds a label field to every log entry.
+type labelHook struct {
+	label string
+}
+
+// NewLabelHook returns a logrus hook that tags log entries with label, so
+// the output of several wick processes can be told apart.
+func NewLabelHook(label string) logrus.Hook {
+	return labelHook{label: label}
+}
+
+func (h labelHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h labelHook) Fire(entry *logrus.Entry) error {
+	entry.Data["label"] = h.label
+	return nil
+}
+
+// SetLabel tags every line logged by wick with label.
+func SetLabel(label string) {
+	logger.AddHook(NewLabelHook(label))
+}
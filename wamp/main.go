@@ -28,6 +28,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/sha256"
+	"crypto/tls"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -35,12 +36,14 @@ import (
 	"github.com/sirupsen/logrus"
 	"golang.org/x/crypto/ed25519"
 	"golang.org/x/crypto/pbkdf2"
-	"log"
+	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gammazero/nexus/v3/client"
@@ -49,94 +52,230 @@ import (
 	"github.com/gammazero/nexus/v3/wamp/crsign"
 )
 
+// errRuntime is returned to callers when a wick-side handler fails.
+const errRuntime = wamp.URI("wamp.error.runtime_error")
+
 var logger *logrus.Logger
 
 func init() {
 	logger = logrus.New()
 }
 
-func connect(url string, cfg client.Config) *client.Client {
-	if strings.HasPrefix(url, "rs") {
-		url = "tcp" + strings.TrimPrefix(url, "rs")
-	} else if strings.HasPrefix(url, "rss") {
-		url = "tcp" + strings.TrimPrefix(url, "rss")
+// SetLogLevel sets the level of messages logged by wick.
+func SetLogLevel(level logrus.Level) {
+	logger.SetLevel(level)
+}
+
+// Limits of the websocket keepalive interval; zero disables keepalive.
+const (
+	MinKeepAlive = time.Second
+	MaxKeepAlive = time.Hour
+)
+
+// ValidateKeepAlive checks that interval is zero or within the allowed range.
+func ValidateKeepAlive(interval time.Duration) error {
+	if interval != 0 && (interval < MinKeepAlive || interval > MaxKeepAlive) {
+		return fmt.Errorf("keepalive must be 0 (disabled) or between %s and %s, got %s", MinKeepAlive,
+			MaxKeepAlive, interval)
 	}
-	session, err := client.ConnectNet(context.Background(), url, cfg)
-	if err != nil {
+	return nil
+}
+
+func connect(clientInfo *ClientInfo, cfg client.Config) *client.Client {
+	if err := ValidateKeepAlive(clientInfo.KeepAlive); err != nil {
 		logger.Fatal(err)
-	} else {
-		// FIXME: use a better logger and only print such messages in debug mode.
-		//logger.Println("Connected to ", baseUrl)
 	}
+	if err := ValidateMaxMsgSize(clientInfo.MaxMsgSize); err != nil {
+		logger.Fatal(err)
+	}
+	if clientInfo.Proxy != "" {
+		if err := ValidateProxy(clientInfo.Proxy); err != nil {
+			logger.Fatal(err)
+		}
+		cfg.WsCfg.ProxyURL = clientInfo.Proxy
+	}
+	cfg.WsCfg.KeepAlive = clientInfo.KeepAlive
+	cfg.RecvLimit = clientInfo.MaxMsgSize
+	if clientInfo.ResponseTimeout > 0 {
+		cfg.ResponseTimeout = clientInfo.ResponseTimeout
+	}
+	cfg.TlsCfg = clientInfo.tlsConfig(clientInfo.Url)
 
+	session, err := dial(clientInfo, cfg)
+	if err != nil {
+		notifyError(err)
+		logger.Fatal(err)
+	}
+	logKeepAlive(clientInfo.Url, clientInfo.KeepAlive)
 	return session
 }
 
-func ConnectAnonymous(url string, realm string, serializer serialize.Serialization, authid string,
-	authrole string) *client.Client {
+// dial opens a session to the router with cfg. It remembers how, so a
+// ReconnectingSession can open a new one when the session is lost.
+func dial(clientInfo *ClientInfo, cfg client.Config) (*client.Client, error) {
+	ctx := context.Background()
+	if clientInfo.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, clientInfo.ConnectTimeout)
+		defer cancel()
+	}
+	peer, err := dialPeer(ctx, clientInfo.Url, &cfg, clientInfo.Headers)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("could not connect to %s within %s", clientInfo.Url, clientInfo.ConnectTimeout)
+		}
+		return nil, err
+	}
+	if chaos != nil {
+		peer = newChaosPeer(peer, chaos)
+	}
+
+	observed := newObservedPeer(peer, clientInfo.Url, cfg.Realm)
+	session, err := client.NewClient(observed, cfg)
+	if err != nil {
+		return nil, err
+	}
+	observed.setProfile(session.RealmDetails())
+	observedPeers.Store(session, observed)
+	sessionDialers.Store(session, func() (*client.Client, error) {
+		return dial(clientInfo, cfg)
+	})
+	go func() {
+		<-session.Done()
+		observedPeers.Delete(session)
+		sessionDialers.Delete(session)
+		takeTestaments(session)
+	}()
+	emitProgress(progressJoined, map[string]interface{}{"url": clientInfo.Url, "realm": cfg.Realm,
+		"session": session.ID()})
+	notifyJoin(session)
+
+	return session, nil
+}
 
+// logKeepAlive reports the keepalive that is in effect for the connection.
+func logKeepAlive(url string, interval time.Duration) {
+	switch {
+	case !strings.HasPrefix(url, "ws") && !strings.HasPrefix(url, "http"):
+		logger.Debugf("Keepalive is not supported on %s, not sending pings", url)
+	case interval == 0:
+		logger.Debug("Keepalive disabled")
+	default:
+		logger.Debugf("Sending websocket pings every %s", interval)
+	}
+}
+
+// ClientInfo holds the details used to connect to a router and join a realm.
+type ClientInfo struct {
+	Url        string
+	Realm      string
+	Serializer serialize.Serialization
+	Authid     string
+	Authrole   string
+
+	// Agent is sent as the HELLO "agent" detail to identify the client.
+	Agent string
+
+	// HelloDetails are merged into the HELLO message details, allowing
+	// arbitrary (possibly draft) protocol extensions to be requested.
+	HelloDetails wamp.Dict
+
+	// ServerName overrides the TLS server name (SNI) sent to the router, for
+	// routers behind SNI-routing proxies. The certificate is verified against
+	// it as well.
+	ServerName string
+
+	// NextProtos are the ALPN protocols offered during the TLS handshake.
+	NextProtos []string
+
+	// TLSConfig is the base of the TLS configuration for secure router URLs,
+	// e.g. with the CA of the router's certificate or client certificates,
+	// see LoadTLSConfig. ServerName, NextProtos and PinnedSHA256 are applied
+	// on top of it.
+	TLSConfig *tls.Config
+
+	// PinnedSHA256 are base64 SHA-256 hashes of the router's certificate or
+	// public key (SPKI). If set, the connection fails unless one matches.
+	PinnedSHA256 []string
+
+	// KeepAlive is the interval between websocket pings, zero disables them.
+	KeepAlive time.Duration
+
+	// MaxMsgSize is the longest message the router may send over rawsocket,
+	// rounded up to a power of two. Zero asks for the maximum of 16 MiB.
+	MaxMsgSize int
+
+	// Headers are sent in the websocket handshake, e.g. for gateways in front
+	// of the router that require an Authorization header.
+	Headers http.Header
+
+	// Proxy is the http:// or socks5:// URL of a proxy to connect to the
+	// router through over websocket. If empty, the proxy in HTTP_PROXY or
+	// HTTPS_PROXY is used, unless NO_PROXY excludes the router.
+	Proxy string
+
+	// ConnectTimeout bounds opening the connection to the router, including
+	// the TLS and transport handshakes. Zero waits as long as the OS does.
+	ConnectTimeout time.Duration
+
+	// ResponseTimeout bounds waiting for the router to answer joining,
+	// subscribing and registering. Zero keeps the nexus default.
+	ResponseTimeout time.Duration
+}
+
+func (c *ClientInfo) helloDetails() wamp.Dict {
 	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
+	if c.Agent != "" {
+		helloDict["agent"] = c.Agent
 	}
 
-	if authrole != "" {
-		helloDict["authrole"] = authrole
+	for key, value := range c.HelloDetails {
+		helloDict[key] = value
 	}
 
-	cfg := client.Config{
-		Realm:         realm,
-		Logger:        logger,
-		HelloDetails:  helloDict,
-		Serialization: serializer,
+	if c.Authid != "" {
+		helloDict["authid"] = c.Authid
 	}
 
-	return connect(url, cfg)
-}
+	if c.Authrole != "" {
+		helloDict["authrole"] = c.Authrole
+	}
 
-func ConnectTicket(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	ticket string) *client.Client {
+	return helloDict
+}
 
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
+func ConnectAnonymous(clientInfo *ClientInfo) *client.Client {
+	cfg := client.Config{
+		Realm:         clientInfo.Realm,
+		Logger:        logger,
+		HelloDetails:  clientInfo.helloDetails(),
+		Serialization: clientInfo.Serializer,
 	}
 
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
+	return connect(clientInfo, cfg)
+}
 
+func ConnectTicket(clientInfo *ClientInfo, ticket string) *client.Client {
 	cfg := client.Config{
-		Realm:        realm,
+		Realm:        clientInfo.Realm,
 		Logger:       logger,
-		HelloDetails: helloDict,
+		HelloDetails: clientInfo.helloDetails(),
 		AuthHandlers: map[string]client.AuthFunc{
 			"ticket": func(c *wamp.Challenge) (string, wamp.Dict) {
 				return ticket, wamp.Dict{}
 			},
 		},
-		Serialization: serializer,
+		Serialization: clientInfo.Serializer,
 	}
 
-	return connect(url, cfg)
+	return connect(clientInfo, cfg)
 }
 
-func ConnectCRA(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	secret string) *client.Client {
-
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
-	}
-
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
-
+func ConnectCRA(clientInfo *ClientInfo, secret string) *client.Client {
 	cfg := client.Config{
-		Realm:        realm,
+		Realm:        clientInfo.Realm,
 		Logger:       logger,
-		HelloDetails: helloDict,
+		HelloDetails: clientInfo.helloDetails(),
 		AuthHandlers: map[string]client.AuthFunc{
 			"wampcra": func(c *wamp.Challenge) (string, wamp.Dict) {
 				ch, _ := wamp.AsString(c.Extra["challenge"])
@@ -170,23 +309,14 @@ func ConnectCRA(url string, realm string, serializer serialize.Serialization, au
 				return crsign.SignChallenge(ch, derivedKey), wamp.Dict{}
 			},
 		},
-		Serialization: serializer,
+		Serialization: clientInfo.Serializer,
 	}
 
-	return connect(url, cfg)
+	return connect(clientInfo, cfg)
 }
 
-func ConnectCryptoSign(url string, realm string, serializer serialize.Serialization, authid string, authrole string,
-	privateKey string) *client.Client {
-
-	helloDict := wamp.Dict{}
-	if authid != "" {
-		helloDict["authid"] = authid
-	}
-
-	if authrole != "" {
-		helloDict["authrole"] = authrole
-	}
+func ConnectCryptoSign(clientInfo *ClientInfo, privateKey string) *client.Client {
+	helloDict := clientInfo.helloDetails()
 
 	privkey, _ := hex.DecodeString(privateKey)
 	var pvk ed25519.PrivateKey
@@ -201,10 +331,15 @@ func ConnectCryptoSign(url string, realm string, serializer serialize.Serializat
 
 	key := pvk.Public().(ed25519.PublicKey)
 	publicKey := hex.EncodeToString(key)
-	helloDict["authextra"] = wamp.Dict{"pubkey": publicKey}
+	authextra, _ := wamp.AsDict(helloDict["authextra"])
+	if authextra == nil {
+		authextra = wamp.Dict{}
+	}
+	authextra["pubkey"] = publicKey
+	helloDict["authextra"] = authextra
 
 	cfg := client.Config{
-		Realm:        realm,
+		Realm:        clientInfo.Realm,
 		Logger:       logger,
 		HelloDetails: helloDict,
 		AuthHandlers: map[string]client.AuthFunc{
@@ -218,15 +353,22 @@ func ConnectCryptoSign(url string, realm string, serializer serialize.Serializat
 				return result, wamp.Dict{}
 			},
 		},
-		Serialization: serializer,
+		Serialization: clientInfo.Serializer,
 	}
 
-	return connect(url, cfg)
+	return connect(clientInfo, cfg)
 }
 
-func Subscribe(session *client.Client, topic string, match string, printDetails bool) {
+func Subscribe(session *client.Client, topics []string, match string, printDetails bool, bufferSize int,
+	onOverflow string, options map[string]string) {
+	// Label events with their topic if they could come from more than one.
+	labelTopics := len(topics) > 1 || match != wamp.MatchExact
+
 	// Define function to handle events received.
-	eventHandler := func(event *wamp.Event) {
+	eventHandler := func(topic string, event *wamp.Event) {
+		if labelTopics {
+			printLabel(fmt.Sprintf("topic: %s", topic))
+		}
 		if printDetails {
 			argsKWArgs(event.Arguments, event.ArgumentsKw, event.Details)
 		} else {
@@ -234,74 +376,201 @@ func Subscribe(session *client.Client, topic string, match string, printDetails
 		}
 	}
 
-	// Subscribe to topic.
-	options := wamp.Dict{wamp.OptMatch: match}
-	err := session.Subscribe(topic, eventHandler, options)
-	if err != nil {
-		logger.Fatal("subscribe error:", err)
-	} else {
-		logger.Printf("Subscribed to topic '%s'\n", topic)
+	// Decouple a slow handler from the session with a bounded buffer.
+	if bufferSize > 0 {
+		buffer := newEventBuffer(bufferSize, onOverflow)
+		handle := eventHandler
+		go func() {
+			for {
+				handle(buffer.pop())
+			}
+		}()
+		eventHandler = buffer.push
+	}
+	watchStats()
+	forward := forwardEvents(session, bufferSize, onOverflow)
+
+	subscribeTo, unsubscribe, done := session.Subscribe, session.Unsubscribe, session.Done()
+	if r := reconnecting(session); r != nil {
+		subscribeTo, unsubscribe, done = r.Subscribe, r.Unsubscribe, r.Done()
+	}
+
+	// Subscribe to topics.
+	subscribeOptions := DictToWampDict(options)
+	subscribeOptions[wamp.OptMatch] = match
+	for _, topic := range topics {
+		subscribedTopic := topic
+		err := subscribeTo(topic, func(event *wamp.Event) {
+			// Pattern subscriptions receive the concrete topic in the details.
+			eventTopic, ok := wamp.AsString(event.Details["topic"])
+			if !ok {
+				eventTopic = subscribedTopic
+			}
+			recordStats(eventTopic, false, event.Arguments, event.ArgumentsKw, false)
+			eventHandler(eventTopic, event)
+			forward(eventTopic, event)
+		}, subscribeOptions)
+		emitProgress(progressSubscribed, withURI(progressStatus(err), topic))
+		if err != nil {
+			logger.Fatal("subscribe error:", err)
+		} else {
+			logger.Printf("Subscribed to topic '%s'\n", topic)
+		}
 	}
+
 	// Wait for CTRL-c or client close while handling events.
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	select {
 	case <-sigChan:
-	case <-session.Done():
+	case <-done:
 		logger.Print("Router gone, exiting")
 		return // router gone, just exit
 	}
 
-	// Unsubscribe from topic.
-	if err = session.Unsubscribe(topic); err != nil {
-		logger.Println("Failed to unsubscribe:", err)
+	// Unsubscribe from topics.
+	for _, topic := range topics {
+		if err := unsubscribe(topic); err != nil {
+			logger.Println("Failed to unsubscribe:", err)
+		}
 	}
 }
 
-func Publish(session *client.Client, topic string, args []string, kwargs map[string]string) {
+func Publish(session *client.Client, topic string, args []string, kwargs map[string]string,
+	options map[string]string) {
 
-	// Publish to topic.
-	options := wamp.Dict{wamp.OptAcknowledge: true}
-	err := session.Publish(topic, options, listToWampList(args), dictToWampDict(kwargs))
+	// A single publish is the first iteration of a topic template.
+	topic = expandIteration(topic, 0)
+	arguments, keywordArguments, publication, correlation, err := publishOnce(session, topic, args, kwargs,
+		options, dataRow(0))
+	recordHistory("publish", topic, arguments, keywordArguments, err)
 	if err != nil {
-		logger.Fatal("Publish error:", err)
+		logger.Fatalf("Publish error%s: %s", correlation, err)
 	} else {
-		logger.Printf("Published to topic '%s'\n", topic)
+		logger.Printf("Published to topic '%s' with publication id %d%s\n", topic, publication, correlation)
 	}
 }
 
-func Register(session *client.Client, procedure string, command string, delay int, invokeCount int) {
+// publishOnce publishes to topic, with the payload of row added, and returns
+// the payload it sent, the publication id and the correlation to log.
+func publishOnce(session *client.Client, topic string, args []string, kwargs map[string]string,
+	options map[string]string, row *DataRow) (wamp.List, wamp.Dict, wamp.ID, string, error) {
 
-	// If the user has called with --invoke-count
-	hasMaxInvokeCount := invokeCount > 0
+	checkURI("publish", topic)
+	payload, err := preparePublish(topic, args, kwargs, options, row)
+	if err != nil {
+		return payload.args, payload.kwargs, 0, "", err
+	}
+	publication, err := payload.send(session, topic)
+	return payload.args, payload.kwargs, publication, payload.correlation, err
+}
+
+// publishPayload is what a publish sends, validated and sealed, with the
+// correlation id added.
+type publishPayload struct {
+	args        wamp.List
+	kwargs      wamp.Dict
+	options     wamp.Dict
+	correlation string
+}
+
+// preparePublish expands and types the args, kwargs and options of a
+// publish to topic, with the payload of row added.
+func preparePublish(topic string, args []string, kwargs map[string]string, options map[string]string,
+	row *DataRow) (publishPayload, error) {
+	arguments, keywordArguments := withRow(listToWampList(args), DictToWampDict(kwargs), row)
+	payload := publishPayload{args: arguments, kwargs: keywordArguments}
+	if err := validatePayload(topic, arguments, keywordArguments); err != nil {
+		return payload, err
+	}
+	sealKwargs(keywordArguments)
+
+	payload.options = DictToWampDict(options)
+	payload.correlation = addCorrelation(payload.options, keywordArguments)
+	return payload, nil
+}
+
+// send publishes the payload to topic. It doesn't change the payload, so it
+// can be sent again.
+func (p publishPayload) send(session *client.Client, topic string) (wamp.ID, error) {
+	publication, err := publishAcknowledged(session, topic, p.options, p.args, p.kwargs)
+	emitProgress(progressPublished, withURI(progressStatus(err), topic))
+	return publication, err
+}
 
-	eventHandler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+// Register registers each procedure in procedures, which maps procedure
+// names to the shell command whose output is returned to callers. Without a
+// command, yieldArgs and yieldKwargs are returned instead, or an empty string
+// if there are none. Every result is delayed by responseDelay.
+func Register(session *client.Client, procedures map[string]string, delay int, invokeCount int,
+	options map[string]string, yieldArgs []string, yieldKwargs map[string]string, responseDelay time.Duration) {
 
-		argsKWArgs(inv.Arguments, inv.ArgumentsKw, nil)
+	names := make([]string, 0, len(procedures))
+	for procedure := range procedures {
+		names = append(names, procedure)
+	}
+	sort.Strings(names)
 
-		result := ""
+	register, unregister, closeSession, done := session.Register, session.Unregister, session.Close,
+		session.Done()
+	if r := reconnecting(session); r != nil {
+		register, unregister, closeSession, done = r.Register, r.Unregister, r.Close, r.Done()
+	}
 
-		if command != "" {
-			err, out, _ := shellOut(command)
-			if err != nil {
-				logger.Println("error: ", err)
+	// If the user has called with --invoke-count, count invocations of all
+	// procedures together.
+	hasMaxInvokeCount := invokeCount > 0
+	var invokeMu sync.Mutex
+
+	newHandler := func(procedure string, command string) client.InvocationHandler {
+		return func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+			if len(procedures) > 1 {
+				printLabel(fmt.Sprintf("procedure: %s", procedure))
 			}
-			result = out
-		}
+			argsKWArgs(inv.Arguments, inv.ArgumentsKw, nil)
 
-		if hasMaxInvokeCount {
-			invokeCount--
-			if invokeCount == 0 {
-				session.Unregister(procedure)
-				time.AfterFunc(1*time.Second, func() {
-					logger.Println("session closing")
-					session.Close()
-				})
+			result := client.InvokeResult{Args: wamp.List{""}}
+
+			failed := false
+			if command != "" {
+				err, out, _ := shellOut(command)
+				if err != nil {
+					logger.Println("error: ", err)
+					failed = true
+				}
+				result.Args = wamp.List{out}
+			} else if len(yieldArgs) > 0 || len(yieldKwargs) > 0 {
+				// Convert on every invocation, so templates yield fresh values.
+				result = client.InvokeResult{Args: listToWampList(yieldArgs), Kwargs: DictToWampDict(yieldKwargs)}
+				sealKwargs(result.Kwargs)
+			}
+			recordStats(procedure, true, inv.Arguments, inv.ArgumentsKw, failed)
+
+			if hasMaxInvokeCount {
+				invokeMu.Lock()
+				invokeCount--
+				if invokeCount == 0 {
+					for _, name := range names {
+						unregister(name)
+					}
+					time.AfterFunc(1*time.Second, func() {
+						logger.Println("session closing")
+						closeSession()
+					})
+				}
+				invokeMu.Unlock()
 			}
-		}
 
-		return client.InvokeResult{Args: wamp.List{result}}
+			if responseDelay > 0 {
+				select {
+				case <-time.After(responseDelay):
+				case <-ctx.Done():
+					return client.InvokeResult{Err: wamp.ErrCanceled}
+				}
+			}
 
+			return result
+		}
 	}
 
 	if delay > 0 {
@@ -309,42 +578,122 @@ func Register(session *client.Client, procedure string, command string, delay in
 		time.Sleep(time.Duration(delay) * time.Second)
 	}
 
-	if err := session.Register(procedure, eventHandler, nil); err != nil {
-		logger.Fatal("Failed to register procedure:", err)
-	} else {
-		logger.Printf("Registered procedure '%s'\n", procedure)
+	for _, procedure := range names {
+		err := register(procedure, newHandler(procedure, procedures[procedure]), DictToWampDict(options))
+		emitProgress(progressRegistered, withURI(progressStatus(err), procedure))
+		if err != nil {
+			logger.Fatal("Failed to register procedure:", err)
+		} else {
+			logger.Printf("Registered procedure '%s'\n", procedure)
+		}
 	}
+	watchStats()
 
 	// Wait for CTRL-c or client close while handling remote procedure calls.
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
 	select {
 	case <-sigChan:
-	case <-session.Done():
+	case <-done:
 		logger.Print("Router gone, exiting")
 		return // router gone, just exit
 	}
 
-	if err := session.Unregister(procedure); err != nil {
-		logger.Println("Failed to unregister procedure:", err)
+	for _, procedure := range names {
+		if err := unregister(procedure); err != nil {
+			logger.Println("Failed to unregister procedure:", err)
+		}
 	}
 
 	logger.Println("Registered procedure with router")
 
 }
 
-func Call(session *client.Client, procedure string, args []string, kwargs map[string]string) {
+// Call calls procedure and prints the first result argument. If expect is
+// set, the outcome is checked against it and wick exits non-zero with a diff
+// if it does not match.
+func Call(session *client.Client, procedure string, args []string, kwargs map[string]string,
+	options map[string]string, expect Expectation, resultPath string) {
 	ctx := context.Background()
+	// A single call is the first iteration of a procedure template.
+	procedure = expandIteration(procedure, 0)
+	checkURI("call", procedure)
 
-	result, err := session.Call(ctx, procedure, nil, listToWampList(args), dictToWampDict(kwargs), nil)
-	if err != nil {
-		logger.Println(err)
-	} else if result != nil && len(result.Arguments) > 0 {
-		jsonString, err := json.MarshalIndent(result.Arguments[0], "", "    ")
+	arguments, keywordArguments := listToWampList(args), DictToWampDict(kwargs)
+	if err := validatePayload(procedure, arguments, keywordArguments); err != nil {
+		logger.Fatal(err)
+	}
+	// Hash the options before the correlation id is added and the payload
+	// before sealing, as both differ every time.
+	callOptions := DictToWampDict(options)
+	key := cacheKey(session, procedure, callOptions, arguments, keywordArguments)
+	sealKwargs(keywordArguments)
+	if _, _, err := expect.parse(); err != nil {
+		logger.Fatal(err)
+	}
+
+	var download *resultFile
+	var progress client.ProgressHandler
+	if resultPath != "" {
+		var err error
+		if download, err = newResultFile(resultPath); err != nil {
+			logger.Fatal(err)
+		}
+		progress = func(result *wamp.Result) {
+			if err := download.write(result); err != nil {
+				logger.Fatal(err)
+			}
+		}
+	}
+
+	correlation := addCorrelation(callOptions, keywordArguments)
+	result, cached := cachedCall(procedure, key)
+	var err error
+	if !cached {
+		result, err = session.Call(ctx, procedure, callOptions, arguments, keywordArguments, progress)
+		recordHistory("call", procedure, arguments, keywordArguments, err)
+		emitProgress(progressCalled, withURI(progressStatus(err), procedure))
+		if err == nil {
+			cacheResult(procedure, key, result)
+		}
+	}
+	if result != nil {
+		result.ArgumentsKw = unsealKwargs(result.ArgumentsKw)
+	}
+	if expect.isSet() {
+		mismatch, checkErr := expect.check(result, err)
+		if checkErr != nil {
+			logger.Fatal(checkErr)
+		}
+		if mismatch != "" {
+			fmt.Fprintln(os.Stderr, mismatch)
+			logger.Fatalf("Result of '%s' did not match expectations%s", procedure, correlation)
+		}
 		if err != nil {
+			logger.Printf("Call failed with the expected error %s%s\n", expect.Error, correlation)
+			return
+		}
+	}
+	if err != nil {
+		logger.Println(err.Error() + correlation)
+	} else if correlation != "" && !cached {
+		logger.Printf("Called procedure '%s'%s\n", procedure, correlation)
+	}
+
+	if download != nil {
+		if err == nil && result != nil {
+			if err = download.write(result); err != nil {
+				logger.Fatal(err)
+			}
+		}
+		if err = download.close(); err != nil {
 			logger.Fatal(err)
 		}
-		fmt.Println(string(jsonString))
+		return
+	}
+
+	if err == nil {
+		printResult(result, nil)
 	}
 }
 
@@ -355,80 +704,85 @@ func listToWampList(args []string) wamp.List {
 		return wamp.List{}
 	}
 
-	for _, value := range args {
-
-		var mapJson map[string]interface{}
-		var mapList []map[string]interface{}
-
-		if number, errNumber := strconv.Atoi(value); errNumber == nil {
-			arguments = append(arguments, number)
-		} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
-			arguments = append(arguments, float)
-		} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
-			arguments = append(arguments, boolean)
-		} else if errJson := json.Unmarshal([]byte(value), &mapJson); errJson == nil {
-			arguments = append(arguments, mapJson)
-		} else if errList := json.Unmarshal([]byte(value), &mapList); errList == nil {
-			arguments = append(arguments, mapList)
-		} else {
-			arguments = append(arguments, value)
+	for i, value := range args {
+		value = expandTemplate(value)
+		typed, isString := inferValue(value)
+		if isString && strictArgs {
+			if err := checkStrictValue(value); err != nil {
+				logger.Fatalf("Invalid argument %d: %s", i+1, err)
+			}
 		}
+		arguments = append(arguments, typed)
 	}
 
 	return arguments
 }
 
-func dictToWampDict(kwargs map[string]string) wamp.Dict {
+// DictToWampDict converts string values to WAMP values, inferring the type of
+// each value (number, boolean, JSON object or list, or plain string) after
+// expanding templates.
+func DictToWampDict(kwargs map[string]string) wamp.Dict {
 	var keywordArguments wamp.Dict = make(map[string]interface{})
 
 	for key, value := range kwargs {
-
-		var mapJson map[string]interface{}
-		var mapList []map[string]interface{}
-
-		if number, errNumber := strconv.Atoi(value); errNumber == nil {
-			keywordArguments[key] = number
-		} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
-			keywordArguments[key] = float
-		} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
-			keywordArguments[key] = boolean
-		} else if errJson := json.Unmarshal([]byte(value), &mapJson); errJson == nil {
-			keywordArguments[key] = mapJson
-		} else if errList := json.Unmarshal([]byte(value), &mapList); errList == nil {
-			keywordArguments[key] = mapList
-		} else {
-			keywordArguments[key] = value
+		value = expandTemplate(value)
+		typed, isString := inferValue(value)
+		if isString && strictArgs {
+			if err := checkStrictValue(value); err != nil {
+				logger.Fatalf("Invalid kwarg '%s': %s", key, err)
+			}
 		}
+		keywordArguments[key] = typed
 	}
 	return keywordArguments
 }
 
+// inferValue converts value to a number, boolean, JSON object or list of
+// objects if it parses as one. Otherwise value is returned as is, and
+// isString is true. Unlike listToWampList, it never expands templates, so it
+// is safe for text received from the router.
+func inferValue(value string) (typed interface{}, isString bool) {
+	var mapJson map[string]interface{}
+	var mapList []map[string]interface{}
+
+	if number, errNumber := strconv.Atoi(value); errNumber == nil {
+		return number, false
+	} else if float, errFloat := strconv.ParseFloat(value, 64); errFloat == nil {
+		return float, false
+	} else if boolean, errBoolean := strconv.ParseBool(value); errBoolean == nil {
+		return boolean, false
+	} else if errJson := json.Unmarshal([]byte(value), &mapJson); errJson == nil {
+		return mapJson, false
+	} else if errList := json.Unmarshal([]byte(value), &mapList); errList == nil {
+		return mapList, false
+	}
+	return value, true
+}
+
 func argsKWArgs(args wamp.List, kwArgs wamp.Dict, details wamp.Dict) {
+	kwArgs = unsealKwargs(kwArgs)
+
 	if details != nil {
 		logger.Println(details)
 	}
 
+	if printTransformed(args, kwArgs) {
+		return
+	}
+
 	if len(args) != 0 {
-		fmt.Println("args:")
-		jsonString, err := json.MarshalIndent(args, "", "    ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Println(string(jsonString))
+		printLabel("args:")
+		printJSON(args)
 	}
 
 	if len(kwArgs) != 0 {
-		fmt.Println("kwargs:")
-		jsonString, err := json.MarshalIndent(kwArgs, "", "    ")
-		if err != nil {
-			log.Fatal(err)
-		}
-		fmt.Println(string(jsonString))
+		printLabel("kwargs:")
+		printJSON(kwArgs)
 	}
 
 	if len(args) == 0 && len(kwArgs) == 0 {
-		fmt.Println("args: []")
-		fmt.Println("kwargs: {}")
+		printLabel("args: []")
+		printLabel("kwargs: {}")
 	}
 }
 
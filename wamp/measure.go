@@ -0,0 +1,112 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// csvHeader names the columns of the file written by EnableCSV.
+var csvHeader = []string{"timestamp", "duration_ms", "success", "error_uri", "payload_bytes"}
+
+var measurements struct {
+	mu     sync.Mutex
+	writer *csv.Writer
+}
+
+// EnableCSV writes a row to the file at path for each call of CallRepeated
+// and each publish of PublishRepeated: when it started, how long it took,
+// whether it succeeded, the URI of its error and the size of its args and
+// kwargs as JSON.
+func EnableCSV(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	writer := csv.NewWriter(file)
+	if err = writer.Write(csvHeader); err != nil {
+		return err
+	}
+	writer.Flush()
+	if err = writer.Error(); err != nil {
+		return err
+	}
+
+	measurements.mu.Lock()
+	defer measurements.mu.Unlock()
+	measurements.writer = writer
+	return nil
+}
+
+// recordMeasurement writes the row of an operation that started at start,
+// if EnableCSV was called.
+func recordMeasurement(start time.Time, args wamp.List, kwargs wamp.Dict, err error) {
+	duration := time.Since(start)
+	measurements.mu.Lock()
+	defer measurements.mu.Unlock()
+	if measurements.writer == nil {
+		return
+	}
+
+	row := []string{
+		start.UTC().Format(time.RFC3339Nano),
+		strconv.FormatFloat(float64(duration)/float64(time.Millisecond), 'f', 3, 64),
+		strconv.FormatBool(err == nil),
+		errorURI(err),
+		strconv.Itoa(payloadSize(args, kwargs)),
+	}
+	// The rows are flushed as they come, so an interrupted run keeps them.
+	measurements.writer.Write(row)
+	measurements.writer.Flush()
+	if err = measurements.writer.Error(); err != nil {
+		logger.Warn("Failed to write CSV: ", err)
+	}
+}
+
+// publishErrorPrefix starts the errors nexus returns for a publish the
+// router refused, followed by the error URI.
+const publishErrorPrefix = "waiting for published message: "
+
+// errorURI returns the URI of the WAMP error err carries, or "".
+func errorURI(err error) string {
+	var rpcErr client.RPCError
+	if errors.As(err, &rpcErr) {
+		return string(rpcErr.Err.Error)
+	}
+	if err != nil && strings.HasPrefix(err.Error(), publishErrorPrefix) {
+		uri := strings.TrimPrefix(err.Error(), publishErrorPrefix)
+		return strings.SplitN(uri, ":", 2)[0]
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/csv"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestRecordMeasurement(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "measurements.csv")
+	if err := EnableCSV(path); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { measurements.writer = nil }()
+
+	start := time.Date(2022, 5, 10, 9, 12, 1, 0, time.UTC)
+	recordMeasurement(start, wamp.List{42}, nil, nil)
+	recordMeasurement(start, nil, wamp.Dict{"a": 1},
+		client.RPCError{Err: &wamp.Error{Error: wamp.ErrNoSuchProcedure}})
+	recordMeasurement(start, nil, nil,
+		errors.New("waiting for published message: wamp.error.not_authorized: denied"))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 || !reflect.DeepEqual(rows[0], csvHeader) {
+		t.Fatalf("got rows %q", rows)
+	}
+	expected := [][]string{
+		{"2022-05-10T09:12:01Z", "true", "", "4"},
+		{"2022-05-10T09:12:01Z", "false", "wamp.error.no_such_procedure", "7"},
+		{"2022-05-10T09:12:01Z", "false", "wamp.error.not_authorized", "0"},
+	}
+	for i, row := range rows[1:] {
+		// The duration is left out, as it depends on when the test runs.
+		got := []string{row[0], row[2], row[3], row[4]}
+		if !reflect.DeepEqual(got, expected[i]) {
+			t.Errorf("row %d: got %q, expected %q", i+1, got, expected[i])
+		}
+	}
+}
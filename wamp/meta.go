@@ -0,0 +1,158 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DefaultMetaConcurrency is how many meta API lookups run at once by default.
+const DefaultMetaConcurrency = 16
+
+var metaConcurrency = DefaultMetaConcurrency
+
+// SetMetaConcurrency sets how many meta API lookups, such as
+// wamp.session.get for every listed session, run at once.
+func SetMetaConcurrency(concurrency int) error {
+	if concurrency < 1 {
+		return fmt.Errorf("meta concurrency must be at least 1, got %d", concurrency)
+	}
+	metaConcurrency = concurrency
+	return nil
+}
+
+// metaLookup is the result of looking up one id.
+type metaLookup struct {
+	id     wamp.ID
+	result interface{}
+	err    error
+}
+
+// lookupEach runs lookup for each of ids and passes the results to each in
+// the order of ids, as soon as they are available. At most metaConcurrency
+// lookups are in flight, so only that many results are held in memory
+// however many ids there are. Lookups that fail, usually because the session
+// or registration went away meanwhile, are logged and skipped. Returning
+// false from each stops the lookups.
+func lookupEach(ids wamp.List, lookup func(ctx context.Context, id wamp.ID) (interface{}, error),
+	each func(id wamp.ID, result interface{}) bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// pending holds the result channels of the lookups in flight, in order.
+	// The lookup each is waiting for is no longer in it.
+	pending := make(chan chan metaLookup, metaConcurrency-1)
+	go func() {
+		defer close(pending)
+		for _, value := range ids {
+			id, ok := wamp.AsID(value)
+			if !ok {
+				continue
+			}
+			done := make(chan metaLookup, 1)
+			select {
+			case pending <- done:
+			case <-ctx.Done():
+				return
+			}
+			go func() {
+				result, err := lookup(ctx, id)
+				done <- metaLookup{id: id, result: result, err: err}
+			}()
+		}
+	}()
+
+	for done := range pending {
+		lookup := <-done
+		if lookup.err != nil {
+			logger.Debugf("Failed to look up %v: %s\n", lookup.id, lookup.err)
+			continue
+		}
+		if !each(lookup.id, lookup.result) {
+			return
+		}
+	}
+}
+
+// metaGetEach calls the meta procedure get, such as wamp.session.get, for
+// each of ids like lookupEach and passes the details it returns to each.
+func metaGetEach(session *client.Client, get wamp.URI, ids wamp.List, each func(id wamp.ID, details wamp.Dict) bool) {
+	lookup := func(ctx context.Context, id wamp.ID) (interface{}, error) {
+		return metaGet(ctx, session, get, id)
+	}
+	lookupEach(ids, lookup, func(id wamp.ID, result interface{}) bool {
+		return each(id, result.(wamp.Dict))
+	})
+}
+
+// metaGet calls the meta procedure get with id and returns the dict it
+// returns.
+func metaGet(ctx context.Context, session *client.Client, get wamp.URI, id wamp.ID) (wamp.Dict, error) {
+	result, err := session.Call(ctx, string(get), nil, wamp.List{id}, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", get, err)
+	}
+	if len(result.Arguments) == 0 {
+		return wamp.Dict{}, nil
+	}
+	details, _ := wamp.AsDict(result.Arguments[0])
+	return details, nil
+}
+
+// sessionIDs returns the ids of the sessions on the realm. The router
+// returns them all in one result, only looking them up is streamed.
+func sessionIDs(session *client.Client) (wamp.List, error) {
+	result, err := session.Call(context.Background(), string(wamp.MetaProcSessionList), nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Arguments) == 0 {
+		return nil, nil
+	}
+	ids, _ := wamp.AsList(result.Arguments[0])
+	return ids, nil
+}
+
+// matchIDs calls the meta procedure list, wamp.registration.list or
+// wamp.subscription.list, and returns the ids it returns by match policy.
+func matchIDs(session *client.Client, list wamp.URI) (map[string]wamp.List, error) {
+	result, err := session.Call(context.Background(), string(list), nil, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	ids := map[string]wamp.List{}
+	if len(result.Arguments) == 0 {
+		return ids, nil
+	}
+	lists, _ := wamp.AsDict(result.Arguments[0])
+	for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+		ids[match], _ = wamp.AsList(lists[match])
+	}
+	return ids, nil
+}
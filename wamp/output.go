@@ -0,0 +1,220 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultMaxPrintBytes is the default limit for printed payloads.
+const DefaultMaxPrintBytes = 64 * 1024
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorBlue   = "\x1b[34m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+)
+
+// renderer prints payloads received from or returned by the router. Output
+// is colorized when it goes to a terminal and large payloads are truncated.
+type renderer struct {
+	out      io.Writer
+	color    bool
+	maxBytes int
+}
+
+var output = &renderer{
+	out:      os.Stdout,
+	color:    isTerminal(os.Stdout) && os.Getenv("NO_COLOR") == "",
+	maxBytes: DefaultMaxPrintBytes,
+}
+
+// SetMaxPrintBytes limits how many bytes of a single payload are printed,
+// larger payloads are truncated. A value of zero or less disables truncation.
+func SetMaxPrintBytes(limit int) {
+	output.maxBytes = limit
+}
+
+// SetColor enables or disables colorized output. By default output is
+// colorized only when stdout is a terminal.
+func SetColor(enabled bool) {
+	output.color = enabled
+	if !enabled {
+		logger.SetFormatter(&logrus.TextFormatter{DisableColors: true})
+	}
+}
+
+// ColorEnabled reports whether output is colorized.
+func ColorEnabled() bool {
+	return output.color
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// printJSON prints value as indented JSON.
+func printJSON(value interface{}) {
+	output.printJSON(value)
+}
+
+// printLabel prints a section label such as "args:".
+func printLabel(label string) {
+	output.printLabel(label)
+}
+
+func (r *renderer) printLabel(label string) {
+	fmt.Fprintln(r.out, r.paint(colorBold, label))
+}
+
+func (r *renderer) printJSON(value interface{}) {
+	jsonString, err := json.MarshalIndent(value, "", "    ")
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	data, note := r.truncate(jsonString)
+	if r.color {
+		data = colorizeJSON(data)
+	}
+	fmt.Fprintln(r.out, string(data))
+	if note != "" {
+		fmt.Fprintln(r.out, r.paint(colorRed, note))
+	}
+}
+
+func (r *renderer) paint(color string, text string) string {
+	if !r.color {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// truncate cuts data to the configured limit, without splitting a UTF-8
+// character, and returns a note with the full size if anything was cut.
+func (r *renderer) truncate(data []byte) ([]byte, string) {
+	if r.maxBytes <= 0 || len(data) <= r.maxBytes {
+		return data, ""
+	}
+
+	cut := r.maxBytes
+	for cut > 0 && !utf8.RuneStart(data[cut]) {
+		cut--
+	}
+
+	return data[:cut], fmt.Sprintf("... (truncated, showing %s of %s, use --full to print everything)",
+		formatBytes(cut), formatBytes(len(data)))
+}
+
+// colorizeJSON adds terminal colors to indented JSON: keys, strings, numbers,
+// booleans and null each get their own color. Truncated input is tolerated.
+func colorizeJSON(data []byte) []byte {
+	colored := make([]byte, 0, len(data)*2)
+	for i := 0; i < len(data); {
+		c := data[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(data) && data[end] != '"' {
+				if data[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(data) {
+				end++
+			} else {
+				end = len(data)
+			}
+
+			color := colorGreen
+			if end < len(data) && data[end] == ':' {
+				color = colorBlue
+			}
+			colored = append(colored, color...)
+			colored = append(colored, data[i:end]...)
+			colored = append(colored, colorReset...)
+			i = end
+		case c == '-' || (c >= '0' && c <= '9'):
+			end := i
+			for end < len(data) && isNumberByte(data[end]) {
+				end++
+			}
+			colored = append(colored, colorCyan...)
+			colored = append(colored, data[i:end]...)
+			colored = append(colored, colorReset...)
+			i = end
+		case c == 't' || c == 'f' || c == 'n':
+			end := i
+			for end < len(data) && data[end] >= 'a' && data[end] <= 'z' {
+				end++
+			}
+			color := colorYellow
+			if c == 'n' {
+				color = colorGray
+			}
+			colored = append(colored, color...)
+			colored = append(colored, data[i:end]...)
+			colored = append(colored, colorReset...)
+			i = end
+		default:
+			colored = append(colored, c)
+			i++
+		}
+	}
+	return colored
+}
+
+func isNumberByte(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '-' || c == '+' || c == '.' || c == 'e' || c == 'E'
+}
+
+func formatBytes(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,413 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// dialPeer connects to the router at routerURL, the same way as
+// client.ConnectNet does, but returns the peer so it can be wrapped before
+// the session is created. Connections are opened with dialConn, and header is
+// sent in the websocket handshake.
+func dialPeer(ctx context.Context, routerURL string, cfg *client.Config, header http.Header) (wamp.Peer, error) {
+	u, err := url.Parse(routerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	// Crossbar calls rawsocket URLs rs:// and rss://.
+	switch u.Scheme {
+	case "rs":
+		u.Scheme = "tcp"
+	case "rss":
+		u.Scheme = "tcps"
+	}
+
+	if !strings.HasPrefix(u.Scheme, "ws") && !strings.HasPrefix(u.Scheme, "http") {
+		if len(header) > 0 {
+			logger.Warn("Headers only apply to websocket transports")
+		}
+		if cfg.WsCfg.ProxyURL != "" {
+			logger.Warn("The proxy only applies to websocket transports")
+		}
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		if u.Scheme == "http" {
+			u.Scheme = "ws"
+		} else {
+			u.Scheme = "wss"
+		}
+		fallthrough
+	case "ws", "wss":
+		if cfg.RecvLimit > 0 {
+			logger.Warn("The max message size only applies to rawsocket transports")
+		}
+		return dialWebsocket(ctx, u.String(), cfg, header)
+	case "tcps", "tcp4s", "tcp6s":
+		u.Scheme = u.Scheme[:len(u.Scheme)-1]
+		tlsConfig := new(tls.Config)
+		if cfg.TlsCfg != nil {
+			tlsConfig = cfg.TlsCfg.Clone()
+		}
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+		return dialRawSocket(ctx, u.Scheme, u.Host, cfg, tlsConfig)
+	case "tcp", "tcp4", "tcp6":
+		return dialRawSocket(ctx, u.Scheme, u.Host, cfg, nil)
+	case "unix":
+		if cfg.TlsCfg != nil {
+			return nil, fmt.Errorf("tls not supported for %s", u.Scheme)
+		}
+		// If a relative path was specified, u.Host is first part of path.
+		return dialRawSocket(ctx, u.Scheme, path.Clean(u.Host+u.Path), cfg, nil)
+	}
+
+	return nil, fmt.Errorf("invalid url: %s", routerURL)
+}
+
+func dialRawSocket(ctx context.Context, network string, addr string, cfg *client.Config,
+	tlsConfig *tls.Config) (wamp.Peer, error) {
+	conn, err := dialConn(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	peer, err := connectRawSocket(ctx, conn, cfg.Serialization, tlsConfig, cfg.RecvLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	logLimits := logger.Debugf
+	if cfg.RecvLimit > 0 {
+		logLimits = logger.Infof
+	}
+	logLimits("Negotiated rawsocket message limits: sending up to %s, receiving up to %s",
+		formatBytes(peer.sendLimit), formatBytes(peer.recvLimit))
+	return peer, nil
+}
+
+// observedPeer wraps the peer of a session to record protocol details that
+// the nexus client does not expose, such as the publication IDs returned in
+// PUBLISHED messages. It also writes the audit log, as every operation sent
+// to the router goes through it.
+type observedPeer struct {
+	wamp.Peer
+	rd chan wamp.Message
+	// replies are answers to requests that could not be sent, and closed is
+	// closed once no more messages are received.
+	replies chan wamp.Message
+	closed  chan struct{}
+
+	url   string
+	realm string
+
+	mu sync.Mutex
+	// publishRequests maps the options of acknowledged publishes in flight
+	// to the request ID of their PUBLISH, and publications the request IDs
+	// to the publication ID the router acknowledged them with.
+	publishRequests map[uintptr]wamp.ID
+	publications    map[wamp.ID]wamp.ID
+	profile         auditProfile
+	audited         map[wamp.ID]auditRecord
+}
+
+// observedPeers maps sessions to their observed peer.
+var observedPeers sync.Map
+
+// errNotSent is the error of requests that could not be sent to the router.
+const errNotSent = wamp.URI("wick.error.not_sent")
+
+func newObservedPeer(peer wamp.Peer, url string, realm string) *observedPeer {
+	p := &observedPeer{
+		Peer:            peer,
+		rd:              make(chan wamp.Message),
+		replies:         make(chan wamp.Message),
+		closed:          make(chan struct{}),
+		url:             url,
+		realm:           realm,
+		publishRequests: map[uintptr]wamp.ID{},
+		publications:    map[wamp.ID]wamp.ID{},
+		audited:         map[wamp.ID]auditRecord{},
+	}
+	go p.recvHandler()
+	return p
+}
+
+// setProfile records who the session joined as, from the WELCOME details.
+func (p *observedPeer) setProfile(details wamp.Dict) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.profile.AuthID, _ = wamp.AsString(details["authid"])
+	p.profile.AuthRole, _ = wamp.AsString(details["authrole"])
+	p.profile.AuthMethod, _ = wamp.AsString(details["authmethod"])
+}
+
+func (p *observedPeer) recvHandler() {
+	defer close(p.rd)
+	defer close(p.closed)
+	recv := p.Peer.Recv()
+	for {
+		var msg wamp.Message
+		select {
+		case received, ok := <-recv:
+			if !ok {
+				p.auditClosed()
+				return
+			}
+			msg = received
+		case msg = <-p.replies:
+		}
+
+		switch msg := msg.(type) {
+		case *wamp.Published:
+			p.mu.Lock()
+			if _, ok := p.publications[msg.Request]; ok {
+				p.publications[msg.Request] = msg.Publication
+			}
+			p.mu.Unlock()
+			p.auditReply(msg.Request, "")
+		case *wamp.Subscribed:
+			p.auditReply(msg.Request, "")
+		case *wamp.Registered:
+			p.auditReply(msg.Request, "")
+		case *wamp.Result:
+			if progress, _ := wamp.AsBool(msg.Details["progress"]); !progress {
+				p.auditReply(msg.Request, "")
+			}
+		case *wamp.Error:
+			p.auditReply(msg.Request, string(msg.Error))
+		}
+		p.rd <- msg
+	}
+}
+
+func (p *observedPeer) observeSend(msg wamp.Message) {
+	if publish, ok := msg.(*wamp.Publish); ok {
+		key := reflect.ValueOf(publish.Options).Pointer()
+		p.mu.Lock()
+		if _, ok := p.publishRequests[key]; ok {
+			p.publishRequests[key] = publish.Request
+			p.publications[publish.Request] = 0
+		}
+		p.mu.Unlock()
+	}
+	p.auditSend(msg)
+}
+
+// requestOf returns the request ID of msg, and whether the router replies to
+// it.
+func requestOf(msg wamp.Message) (wamp.ID, bool) {
+	switch msg := msg.(type) {
+	case *wamp.Call:
+		return msg.Request, true
+	case *wamp.Publish:
+		acknowledge, _ := wamp.AsBool(msg.Options[wamp.OptAcknowledge])
+		return msg.Request, acknowledge
+	case *wamp.Subscribe:
+		return msg.Request, true
+	case *wamp.Unsubscribe:
+		return msg.Request, true
+	case *wamp.Register:
+		return msg.Request, true
+	case *wamp.Unregister:
+		return msg.Request, true
+	}
+	return 0, false
+}
+
+// observeSent finishes observing msg once sent, or once sending it failed
+// with err. The nexus client ignores send errors, so a request the router
+// would reply to is answered with an ERROR instead of waiting for a reply
+// that never comes.
+func (p *observedPeer) observeSent(msg wamp.Message, err error) error {
+	request, replied := requestOf(msg)
+	switch {
+	case err != nil && replied:
+		reply := &wamp.Error{Type: msg.MessageType(), Request: request, Details: wamp.Dict{},
+			Error: errNotSent, Arguments: wamp.List{err.Error()}}
+		select {
+		case p.replies <- reply:
+		case <-p.closed:
+		}
+	case err != nil:
+		logger.Errorf("Failed to send %s: %s", msg.MessageType(), err)
+		p.auditReply(request, string(errNotSent))
+	case !replied:
+		p.auditReply(request, "")
+	}
+	return err
+}
+
+// auditSend starts the audit record of an operation sent to the router. It
+// is written when the router answers, or once sent for publishes that are
+// not acknowledged.
+func (p *observedPeer) auditSend(msg wamp.Message) {
+	if !auditEnabled() {
+		return
+	}
+
+	var request wamp.ID
+	record := auditRecord{URL: p.url, Realm: p.realm}
+	switch msg := msg.(type) {
+	case *wamp.Call:
+		request = msg.Request
+		record.Operation, record.URI = "call", string(msg.Procedure)
+		record.PayloadHash = payloadHash(msg.Arguments, msg.ArgumentsKw)
+	case *wamp.Publish:
+		request = msg.Request
+		record.Operation, record.URI = "publish", string(msg.Topic)
+		record.PayloadHash = payloadHash(msg.Arguments, msg.ArgumentsKw)
+		if acknowledge, _ := wamp.AsBool(msg.Options[wamp.OptAcknowledge]); !acknowledge {
+			record.Status = "sent"
+		}
+	case *wamp.Subscribe:
+		request = msg.Request
+		record.Operation, record.URI = "subscribe", string(msg.Topic)
+	case *wamp.Register:
+		request = msg.Request
+		record.Operation, record.URI = "register", string(msg.Procedure)
+	default:
+		return
+	}
+
+	p.mu.Lock()
+	record.Profile = p.profile
+	p.audited[request] = record
+	p.mu.Unlock()
+}
+
+// auditReply writes the audit record of the operation answered by the
+// router, or sent if it gets no answer, with the error URI if it failed.
+func (p *observedPeer) auditReply(request wamp.ID, errURI string) {
+	p.mu.Lock()
+	record, ok := p.audited[request]
+	delete(p.audited, request)
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	if record.Status == "" {
+		record.Status = "ok"
+	}
+	if errURI != "" {
+		record.Status = "error"
+		record.Error = errURI
+	}
+	writeAudit(record)
+}
+
+// auditClosed writes the audit records of operations the router never
+// answered before the connection closed.
+func (p *observedPeer) auditClosed() {
+	p.mu.Lock()
+	audited := p.audited
+	p.audited = map[wamp.ID]auditRecord{}
+	p.mu.Unlock()
+
+	for _, record := range audited {
+		record.Status = "error"
+		record.Error = "connection closed"
+		writeAudit(record)
+	}
+}
+
+func (p *observedPeer) Recv() <-chan wamp.Message { return p.rd }
+
+func (p *observedPeer) Send(msg wamp.Message) error {
+	p.observeSend(msg)
+	return p.observeSent(msg, p.Peer.Send(msg))
+}
+
+func (p *observedPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	p.observeSend(msg)
+	return p.observeSent(msg, p.Peer.SendCtx(ctx, msg))
+}
+
+func (p *observedPeer) TrySend(msg wamp.Message) error {
+	p.observeSend(msg)
+	return p.observeSent(msg, p.Peer.TrySend(msg))
+}
+
+// expectPublication starts waiting for the publication ID of the PUBLISH
+// sent with options. The nexus client sends it from the publishing
+// goroutine, with the options map it was given, which identifies the request.
+func (p *observedPeer) expectPublication(options wamp.Dict) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.publishRequests[reflect.ValueOf(options).Pointer()] = 0
+}
+
+// takePublication returns the publication ID acknowledging the PUBLISH sent
+// with options, and stops waiting for it.
+func (p *observedPeer) takePublication(options wamp.Dict) wamp.ID {
+	key := reflect.ValueOf(options).Pointer()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	request := p.publishRequests[key]
+	publication := p.publications[request]
+	delete(p.publishRequests, key)
+	delete(p.publications, request)
+	return publication
+}
+
+// publishAcknowledged publishes with acknowledge=true and returns the
+// publication ID assigned by the router.
+func publishAcknowledged(session *client.Client, topic string, options wamp.Dict, args wamp.List,
+	kwargs wamp.Dict) (wamp.ID, error) {
+
+	// Publish with options of our own, their map identifies the request.
+	publishOptions := wamp.Dict{wamp.OptAcknowledge: true}
+	for key, value := range options {
+		if key != wamp.OptAcknowledge {
+			publishOptions[key] = value
+		}
+	}
+
+	value, ok := observedPeers.Load(session)
+	if !ok {
+		return 0, session.Publish(topic, publishOptions, args, kwargs)
+	}
+	peer := value.(*observedPeer)
+
+	peer.expectPublication(publishOptions)
+	err := session.Publish(topic, publishOptions, args, kwargs)
+	publication := peer.takePublication(publishOptions)
+	return publication, err
+}
@@ -0,0 +1,51 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"fmt"
+	"strings"
+)
+
+var uriPrefix string
+
+// SetURIPrefix sets the prefix that relative URIs, those starting with a
+// dot, are expanded with by ResolveURI.
+func SetURIPrefix(prefix string) {
+	uriPrefix = strings.TrimSuffix(prefix, ".")
+}
+
+// ResolveURI expands a relative URI such as .orders.list to
+// com.example.app.orders.list with the prefix set with SetURIPrefix. Other
+// URIs are returned unchanged.
+func ResolveURI(uri string) (string, error) {
+	if !strings.HasPrefix(uri, ".") {
+		return uri, nil
+	}
+	if uriPrefix == "" {
+		return "", fmt.Errorf("relative URI '%s' needs a URI prefix", uri)
+	}
+	return uriPrefix + uri, nil
+}
@@ -0,0 +1,190 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DefaultPresencePrefix is the topic prefix of presence events.
+const DefaultPresencePrefix = "wick.presence"
+
+// presenceMissedBeats is how many heartbeats a member may miss before
+// watchers consider it gone.
+const presenceMissedBeats = 3
+
+// Presence events are published to <prefix>.join, <prefix>.heartbeat and
+// <prefix>.leave with the member id and heartbeat interval as kwargs. The
+// leave event is a testament, so the router publishes it even when the
+// announcing session dies without saying goodbye.
+const (
+	presenceJoin      = "join"
+	presenceHeartbeat = "heartbeat"
+	presenceLeave     = "leave"
+)
+
+// AnnouncePresence joins the presence group under prefix as id and sends
+// heartbeats every interval until CTRL-c or the router closes the session.
+func AnnouncePresence(session *client.Client, prefix string, id string, interval time.Duration) {
+	if interval <= 0 {
+		logger.Fatal("presence interval must be positive")
+	}
+	kwargs := wamp.Dict{"id": id, "interval": interval.Seconds()}
+
+	leave := presenceTopic(prefix, presenceLeave)
+	if err := addTestament(session, leave, wamp.List{}, wamp.Dict{"id": id}, nil, TestamentDestroyed); err != nil {
+		logger.Fatal(err)
+	}
+	if _, err := publishAcknowledged(session, presenceTopic(prefix, presenceJoin), nil, nil, kwargs); err != nil {
+		logger.Fatal("Failed to announce presence: ", err)
+	}
+	logger.Printf("Announced presence of '%s' on '%s'\n", id, prefix)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	for {
+		select {
+		case <-ticker.C:
+			if err := session.Publish(presenceTopic(prefix, presenceHeartbeat), nil, nil, kwargs); err != nil {
+				logger.Println("Failed to send heartbeat:", err)
+			}
+		case <-sigChan:
+			return
+		case <-session.Done():
+			logger.Print("Router gone, exiting")
+			return
+		}
+	}
+}
+
+// presenceMember is a member seen by WatchPresence.
+type presenceMember struct {
+	lastSeen time.Time
+	interval time.Duration
+}
+
+// WatchPresence follows the presence group under prefix and logs members as
+// they join, leave or miss heartbeats, until CTRL-c or the router closes the
+// session.
+func WatchPresence(session *client.Client, prefix string) {
+	var mu sync.Mutex
+	members := map[string]*presenceMember{}
+
+	handler := func(event *wamp.Event) {
+		topic, _ := wamp.AsString(event.Details["topic"])
+		id, ok := wamp.AsString(event.ArgumentsKw["id"])
+		if !ok {
+			return
+		}
+		interval := presenceInterval(event.ArgumentsKw["interval"])
+
+		mu.Lock()
+		defer mu.Unlock()
+		member, known := members[id]
+		switch topic {
+		case presenceTopic(prefix, presenceLeave):
+			if known {
+				delete(members, id)
+				logger.Printf("'%s' left (%d present)\n", id, len(members))
+			}
+		case presenceTopic(prefix, presenceJoin), presenceTopic(prefix, presenceHeartbeat):
+			if !known {
+				member = &presenceMember{}
+				members[id] = member
+				logger.Printf("'%s' joined (%d present)\n", id, len(members))
+			}
+			member.lastSeen = time.Now()
+			member.interval = interval
+		}
+	}
+
+	options := wamp.Dict{wamp.OptMatch: wamp.MatchPrefix}
+	if err := session.Subscribe(prefix+".", handler, options); err != nil {
+		logger.Fatal("subscribe error:", err)
+	}
+	logger.Printf("Watching presence on '%s'\n", prefix)
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	for {
+		select {
+		case <-ticker.C:
+			mu.Lock()
+			for _, id := range expiredMembers(members, time.Now()) {
+				delete(members, id)
+				logger.Printf("'%s' missed %d heartbeats (%d present)\n", id, presenceMissedBeats, len(members))
+			}
+			mu.Unlock()
+		case <-sigChan:
+			return
+		case <-session.Done():
+			logger.Print("Router gone, exiting")
+			return
+		}
+	}
+}
+
+// expiredMembers returns the sorted ids of members that missed too many
+// heartbeats at now.
+func expiredMembers(members map[string]*presenceMember, now time.Time) []string {
+	var expired []string
+	for id, member := range members {
+		if member.interval > 0 && now.Sub(member.lastSeen) > presenceMissedBeats*member.interval {
+			expired = append(expired, id)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+func presenceInterval(value interface{}) time.Duration {
+	switch seconds := value.(type) {
+	case float64:
+		return time.Duration(seconds * float64(time.Second))
+	case float32:
+		return time.Duration(float64(seconds) * float64(time.Second))
+	}
+	if seconds, ok := wamp.AsInt64(value); ok {
+		return time.Duration(seconds) * time.Second
+	}
+	return 0
+}
+
+// presenceTopic returns the topic of kind presence events under prefix.
+func presenceTopic(prefix string, kind string) string {
+	return fmt.Sprintf("%s.%s", prefix, kind)
+}
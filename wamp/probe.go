@@ -0,0 +1,166 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Operations that can be probed by ProbePermissions.
+const (
+	ProbeRegister  = "register"
+	ProbeCall      = "call"
+	ProbeSubscribe = "subscribe"
+	ProbePublish   = "publish"
+)
+
+// probeTimeout bounds how long a probing call waits for the callee.
+const probeTimeout = 5 * time.Second
+
+// authorization errors returned by routers when an operation is denied.
+var deniedErrors = []wamp.URI{wamp.ErrNotAuthorized, wamp.ErrAuthorizationFailed}
+
+// ProbePermissions attempts each of operations on every URI and writes
+// whether the router allowed or denied it to output. If no URIs are given,
+// the procedures and topics in use on the realm are discovered through the
+// meta API. Successful registrations and subscriptions are removed again.
+func ProbePermissions(session *client.Client, uris []string, operations []string, output io.Writer) {
+	if len(uris) == 0 {
+		uris = discoverURIs(session)
+		if len(uris) == 0 {
+			logger.Fatal("No URIs given and none discovered on the realm")
+		}
+	}
+
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "URI\t%s\n", strings.ToUpper(strings.Join(operations, "\t")))
+	for _, uri := range uris {
+		results := make([]string, len(operations))
+		for i, operation := range operations {
+			results[i] = probe(session, uri, operation)
+		}
+		fmt.Fprintf(w, "%s\t%s\n", uri, strings.Join(results, "\t"))
+	}
+	w.Flush()
+}
+
+// probe attempts operation on uri and describes the outcome.
+func probe(session *client.Client, uri string, operation string) string {
+	var err error
+	switch operation {
+	case ProbeRegister:
+		noop := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+			return client.InvokeResult{}
+		}
+		if err = session.Register(uri, noop, nil); err == nil {
+			session.Unregister(uri)
+		} else if strings.Contains(err.Error(), string(wamp.ErrProcedureAlreadyExists)) {
+			return "allowed (already registered)"
+		}
+	case ProbeCall:
+		ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+		defer cancel()
+		_, err = session.Call(ctx, uri, nil, nil, nil, nil)
+		var rpcErr client.RPCError
+		if errors.As(err, &rpcErr) && !isDenied(rpcErr.Err.Error) {
+			if rpcErr.Err.Error == wamp.ErrNoSuchProcedure {
+				return "no such procedure"
+			}
+			return fmt.Sprintf("allowed (%s)", rpcErr.Err.Error)
+		} else if errors.Is(err, context.DeadlineExceeded) {
+			return "allowed (timeout)"
+		}
+	case ProbeSubscribe:
+		if err = session.Subscribe(uri, func(*wamp.Event) {}, nil); err == nil {
+			session.Unsubscribe(uri)
+		}
+	case ProbePublish:
+		_, err = publishAcknowledged(session, uri, nil, nil, nil)
+	}
+
+	if err == nil {
+		return "allowed"
+	}
+	for _, denied := range deniedErrors {
+		if strings.Contains(err.Error(), string(denied)) {
+			return "denied"
+		}
+	}
+	logger.Debugf("Probing %s of '%s' failed: %s\n", operation, uri, err)
+	return "error"
+}
+
+func isDenied(uri wamp.URI) bool {
+	for _, denied := range deniedErrors {
+		if uri == denied {
+			return true
+		}
+	}
+	return false
+}
+
+// discoverURIs returns the exact procedures and topics currently registered
+// or subscribed on the realm.
+func discoverURIs(session *client.Client) []string {
+	seen := map[string]bool{}
+
+	procedures, err := listProcedures(session)
+	if err != nil {
+		logger.Fatal("Failed to list registrations: ", err)
+	}
+	for _, procedure := range procedures {
+		if procedure.Match == wamp.MatchExact {
+			seen[procedure.URI] = true
+		}
+	}
+
+	ids, err := matchIDs(session, wamp.MetaProcSubList)
+	if err != nil {
+		logger.Fatal("Failed to list subscriptions: ", err)
+	}
+	metaGetEach(session, wamp.MetaProcSubGet, ids[wamp.MatchExact], func(id wamp.ID, details wamp.Dict) bool {
+		if uri, _ := wamp.AsString(details["uri"]); uri != "" && !strings.HasPrefix(uri, "wamp.") {
+			seen[uri] = true
+		}
+		return true
+	})
+
+	uris := make([]string, 0, len(seen))
+	for uri := range seen {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
@@ -0,0 +1,92 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Progress events emitted with EnableProgress.
+const (
+	progressJoined     = "joined"
+	progressSubscribed = "subscribed"
+	progressRegistered = "registered"
+	progressPublished  = "published"
+	progressCalled     = "call_completed"
+)
+
+var progress struct {
+	mu    sync.Mutex
+	out   *json.Encoder
+	calls uint64
+}
+
+// EnableProgress writes a JSON line to w for each step of a long running
+// operation (joining, subscribing, registering, completing calls and
+// publishes), so wrappers can display live status.
+func EnableProgress(w io.Writer) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	progress.out = json.NewEncoder(w)
+}
+
+// emitProgress writes a progress record for event with the given fields.
+func emitProgress(event string, fields map[string]interface{}) {
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if progress.out == nil {
+		return
+	}
+
+	record := map[string]interface{}{
+		"time":  time.Now().UTC().Format(time.RFC3339Nano),
+		"event": event,
+	}
+	for key, value := range fields {
+		record[key] = value
+	}
+	if event == progressCalled {
+		progress.calls++
+		record["count"] = progress.calls
+	}
+	_ = progress.out.Encode(record)
+}
+
+// progressStatus returns the status field of a progress record for err.
+func progressStatus(err error) map[string]interface{} {
+	if err != nil {
+		return map[string]interface{}{"status": "error", "error": err.Error()}
+	}
+	return map[string]interface{}{"status": "ok"}
+}
+
+// withURI adds the uri field to fields.
+func withURI(fields map[string]interface{}, uri string) map[string]interface{} {
+	fields["uri"] = uri
+	return fields
+}
@@ -0,0 +1,121 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressLogInterval is how often progress is logged when stderr is not a
+// terminal to draw a progress bar on.
+const progressLogInterval = 10 * time.Second
+
+// progressBar shows how many of total operations are done, with their rate,
+// the time left and how many failed. It draws a bar on stderr when that is a
+// terminal, and logs a line every progressLogInterval otherwise. It is safe
+// for concurrent use.
+type progressBar struct {
+	mu       sync.Mutex
+	total    int
+	unit     string
+	terminal bool
+	start    time.Time
+	logged   time.Time
+	done     int
+	failed   int
+}
+
+func newProgressBar(total int, unit string) *progressBar {
+	now := time.Now()
+	return &progressBar{total: total, unit: unit, terminal: isTerminal(os.Stderr), start: now, logged: now}
+}
+
+// add counts an operation as done, and as failed if err is not nil.
+func (p *progressBar) add(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done++
+	if err != nil {
+		p.failed++
+	}
+
+	if p.terminal {
+		p.render()
+	} else if time.Since(p.logged) >= progressLogInterval {
+		p.logged = time.Now()
+		logger.Infof("%s\n", p.status())
+	}
+}
+
+// print runs fn, which prints a line, with the bar cleared meanwhile so the
+// line isn't mixed up with it.
+func (p *progressBar) print(fn func()) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminal && p.done > 0 {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	fn()
+	if p.terminal && p.done > 0 {
+		p.render()
+	}
+}
+
+func (p *progressBar) render() {
+	if p.total == 0 {
+		return
+	}
+	width := p.done * progressBarWidth / p.total
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %s\033[K", strings.Repeat("=", width),
+		strings.Repeat(" ", progressBarWidth-width), p.status())
+}
+
+// status describes the progress, e.g. "40 / 100 calls, 2 failed, 20.0/s,
+// 3s left".
+func (p *progressBar) status() string {
+	status := fmt.Sprintf("%d / %d %s", p.done, p.total, p.unit)
+	if p.failed > 0 {
+		status += fmt.Sprintf(", %d failed", p.failed)
+	}
+	elapsed := time.Since(p.start)
+	if p.done > 0 && elapsed > 0 {
+		rate := float64(p.done) / elapsed.Seconds()
+		left := time.Duration(float64(p.total-p.done) / rate * float64(time.Second))
+		status += fmt.Sprintf(", %.1f/s, %s left", rate, left.Round(time.Second))
+	}
+	return status
+}
+
+func (p *progressBar) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.terminal && p.done > 0 {
+		fmt.Fprintln(os.Stderr)
+	}
+}
@@ -0,0 +1,194 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// queuedEvent is a line of the queue file.
+type queuedEvent struct {
+	Topic  string                 `json:"topic"`
+	Args   []interface{}          `json:"args,omitempty"`
+	Kwargs map[string]interface{} `json:"kwargs,omitempty"`
+}
+
+// diskQueue is a FIFO of events kept in a file, so events survive the
+// destination router, or wick, going down. Events are appended to the queue
+// file, and the offset file holds how many bytes of it were delivered.
+type diskQueue struct {
+	mu         sync.Mutex
+	file       *os.File
+	offsetPath string
+	offset     int64
+	size       int64
+	ready      chan struct{}
+}
+
+// openDiskQueue opens the queue in dir, creating it if needed. Events left
+// from an earlier run come first.
+func openDiskQueue(dir string) (*diskQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(dir, "queue.jsonl"), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	q := &diskQueue{file: file, offsetPath: filepath.Join(dir, "queue.offset"), size: info.Size(),
+		ready: make(chan struct{}, 1)}
+	if data, err := os.ReadFile(q.offsetPath); err == nil {
+		if offset, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil &&
+			offset >= 0 && offset <= q.size {
+			q.offset = offset
+		}
+	} else if !os.IsNotExist(err) {
+		file.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// pending returns the number of events not delivered yet.
+func (q *diskQueue) pending() (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	data := make([]byte, q.size-q.offset)
+	if _, err := q.file.ReadAt(data, q.offset); err != nil && err != io.EOF {
+		return 0, err
+	}
+	return bytes.Count(data, []byte("\n")), nil
+}
+
+// push appends event to the queue.
+func (q *diskQueue) push(topic string, args wamp.List, kwargs wamp.Dict) error {
+	line, err := json.Marshal(queuedEvent{Topic: topic, Args: args, Kwargs: kwargs})
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	n, err := q.file.Write(append(line, '\n'))
+	q.size += int64(n)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+// peek returns the oldest event not delivered yet and the offset following
+// it, to pass to ack once it is. ok is false if the queue is empty. Lines
+// that can't be read are returned as an error with the offset to skip them.
+func (q *diskQueue) peek() (event queuedEvent, next int64, ok bool, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.offset >= q.size {
+		return event, 0, false, nil
+	}
+
+	reader := bufio.NewReader(io.NewSectionReader(q.file, q.offset, q.size-q.offset))
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		// Only a write cut short by a crash leaves a line unterminated.
+		return event, q.size, false, fmt.Errorf("skipping incomplete queued event: %w", err)
+	}
+	next = q.offset + int64(len(line))
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	if err = decoder.Decode(&event); err != nil {
+		return event, next, false, fmt.Errorf("skipping invalid queued event: %w", err)
+	}
+	for i := range event.Args {
+		event.Args[i] = fromJSONNumbers(event.Args[i])
+	}
+	for key := range event.Kwargs {
+		event.Kwargs[key] = fromJSONNumbers(event.Kwargs[key])
+	}
+	return event, next, true, nil
+}
+
+// ack records that the events up to offset next were delivered, emptying
+// the queue file once all were.
+func (q *diskQueue) ack(next int64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.offset = next
+	if q.offset >= q.size {
+		if err := q.file.Truncate(0); err != nil {
+			return err
+		}
+		q.offset, q.size = 0, 0
+	}
+	return writeFileAtomic(q.offsetPath, []byte(strconv.FormatInt(q.offset, 10)+"\n"))
+}
+
+// wait waits until an event is pushed, or done is closed. It reports
+// whether an event was pushed.
+func (q *diskQueue) wait(done <-chan struct{}) bool {
+	select {
+	case <-q.ready:
+		return true
+	case <-done:
+		return false
+	}
+}
+
+// writeFileAtomic replaces the file at path with data, so it never holds
+// only part of it.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,112 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestDiskQueue(t *testing.T) {
+	dir := t.TempDir()
+	q, err := openDiskQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, topic := range []string{"a", "b", "c"} {
+		if err = q.push(topic, wamp.List{1, "x"}, wamp.Dict{"n": 2.5}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	event, next, ok, err := q.peek()
+	if err != nil || !ok || event.Topic != "a" {
+		t.Fatalf("got %+v, %v, %v, expected event a", event, ok, err)
+	}
+	if !reflect.DeepEqual(event.Args, []interface{}{int64(1), "x"}) ||
+		!reflect.DeepEqual(event.Kwargs, map[string]interface{}{"n": 2.5}) {
+		t.Errorf("got args %#v, kwargs %#v", event.Args, event.Kwargs)
+	}
+	if err = q.ack(next); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new run goes on after the delivered events.
+	q.file.Close()
+	if q, err = openDiskQueue(dir); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := q.pending(); err != nil || pending != 2 {
+		t.Errorf("got %d pending events, %v, expected 2", pending, err)
+	}
+	for _, topic := range []string{"b", "c"} {
+		event, next, ok, err = q.peek()
+		if err != nil || !ok || event.Topic != topic {
+			t.Fatalf("got %+v, %v, %v, expected event %s", event, ok, err, topic)
+		}
+		if err = q.ack(next); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, ok, err = q.peek(); ok || err != nil {
+		t.Errorf("expected the queue to be empty, got %v, %v", ok, err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, "queue.jsonl")); err != nil || info.Size() != 0 {
+		t.Errorf("expected the drained queue file to be emptied: %v", err)
+	}
+	q.file.Close()
+}
+
+func TestDiskQueueIncompleteEvent(t *testing.T) {
+	dir := t.TempDir()
+	data := "{\"topic\":\"a\"}\n{\"topic\":\"b\",\"ar"
+	if err := os.WriteFile(filepath.Join(dir, "queue.jsonl"), []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	q, err := openDiskQueue(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.file.Close()
+
+	event, next, ok, err := q.peek()
+	if err != nil || !ok || event.Topic != "a" {
+		t.Fatalf("got %+v, %v, %v, expected event a", event, ok, err)
+	}
+	q.ack(next)
+	if _, next, ok, err = q.peek(); ok || err == nil {
+		t.Fatal("expected the incomplete event to be an error")
+	}
+	if err = q.ack(next); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, ok, err = q.peek(); ok || err != nil {
+		t.Errorf("expected the queue to be empty, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,338 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// The nexus rawsocket client dials and handshakes internally, so wick has
+// its own to run the transport over connections it dialed itself and to
+// know the message length limits negotiated with the router.
+
+const (
+	rawSocketMagic = 0x7f
+
+	rawSocketMessage = 0
+	rawSocketPing    = 1
+	rawSocketPong    = 2
+)
+
+// Limits of the rawsocket message length wick can ask the router for.
+const (
+	MinMaxMsgSize = 1 << 9
+	MaxMaxMsgSize = 1 << 24
+)
+
+// ValidateMaxMsgSize checks that size is zero (the maximum) or within the
+// lengths the rawsocket handshake can express.
+func ValidateMaxMsgSize(size int) error {
+	if size != 0 && (size < MinMaxMsgSize || size > MaxMaxMsgSize) {
+		return fmt.Errorf("max message size must be 0 (maximum) or between %d and %d bytes, got %d",
+			MinMaxMsgSize, MaxMaxMsgSize, size)
+	}
+	return nil
+}
+
+// rawSocketErrors are the reasons a router can give for refusing a
+// rawsocket handshake.
+var rawSocketErrors = map[byte]string{
+	1: "serializer unsupported",
+	2: "maximum message length unacceptable",
+	3: "use of reserved bits (unsupported feature)",
+	4: "maximum connection count reached",
+}
+
+// rawSocketPeer runs the WAMP rawsocket transport over conn.
+type rawSocketPeer struct {
+	conn       net.Conn
+	serializer serialize.Serializer
+	// sendLimit is the longest message the router accepts, recvLimit the
+	// longest message wick accepts.
+	sendLimit int
+	recvLimit int
+
+	rd chan wamp.Message
+	wr chan rawSocketFrame
+
+	// writeMu serializes writes of messages and of PONG replies.
+	writeMu sync.Mutex
+
+	ctx        context.Context
+	cancel     context.CancelFunc
+	writerDone chan struct{}
+	closeOnce  sync.Once
+}
+
+// connectRawSocket performs the client side of the rawsocket handshake on
+// conn, securing it with TLS first if tlsConfig is not nil. The router is
+// asked to send messages of at most recvLimit bytes, rounded up to a power
+// of two; zero or less asks for the maximum of 16 MiB.
+func connectRawSocket(ctx context.Context, conn net.Conn, serialization serialize.Serialization,
+	tlsConfig *tls.Config, recvLimit int) (*rawSocketPeer, error) {
+	// The handshake itself knows nothing about ctx, bound it with a deadline.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	peer, err := rawSocketHandshake(conn, serialization, recvLimit)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return peer, nil
+}
+
+func rawSocketHandshake(conn net.Conn, serialization serialize.Serialization, recvLimit int) (*rawSocketPeer, error) {
+	var protocol byte
+	var serializer serialize.Serializer
+	switch serialization {
+	case serialize.JSON:
+		protocol, serializer = 1, &serialize.JSONSerializer{}
+	case serialize.MSGPACK:
+		protocol, serializer = 2, &serialize.MessagePackSerializer{}
+	case serialize.CBOR:
+		protocol, serializer = 3, &serialize.CBORSerializer{}
+	default:
+		return nil, errors.New("serialization not supported by rawsocket")
+	}
+
+	maxLength := rawSocketLengthExponent(recvLimit)
+	if _, err := conn.Write([]byte{rawSocketMagic, maxLength<<4 | protocol, 0, 0}); err != nil {
+		return nil, fmt.Errorf("error sending handshake: %w", err)
+	}
+
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return nil, fmt.Errorf("error reading handshake: %w", err)
+	}
+	if reply[0] != rawSocketMagic {
+		return nil, errors.New("not a rawsocket handshake")
+	}
+	if reply[1]&0xf == 0 {
+		if reason, ok := rawSocketErrors[reply[1]>>4]; ok {
+			return nil, fmt.Errorf("router refused rawsocket handshake: %s", reason)
+		}
+		return nil, fmt.Errorf("router refused rawsocket handshake with error %d", reply[1]>>4)
+	}
+	if reply[1]&0xf != protocol {
+		return nil, errors.New("serializer mismatch")
+	}
+
+	p := &rawSocketPeer{
+		conn:       conn,
+		serializer: serializer,
+		sendLimit:  rawSocketLength(reply[1] >> 4),
+		recvLimit:  rawSocketLength(maxLength),
+		rd:         make(chan wamp.Message),
+		wr:         make(chan rawSocketFrame, 16),
+		writerDone: make(chan struct{}),
+	}
+	p.ctx, p.cancel = context.WithCancel(context.Background())
+	go p.sendHandler()
+	go p.recvHandler()
+	return p, nil
+}
+
+// rawSocketLengthExponent returns the handshake exponent of the smallest
+// length limit that is at least limit.
+func rawSocketLengthExponent(limit int) byte {
+	if limit > 0 {
+		for exponent := byte(0); exponent < 0xf; exponent++ {
+			if rawSocketLength(exponent) >= limit {
+				return exponent
+			}
+		}
+	}
+	return 0xf
+}
+
+// rawSocketLength returns the length limit of a handshake exponent. The
+// largest limit is one byte short of 16 MB, as the 3 byte frame header can't
+// hold more.
+func rawSocketLength(exponent byte) int {
+	if length := 1 << (exponent + 9); length < 1<<24 {
+		return length
+	}
+	return 1<<24 - 1
+}
+
+func (p *rawSocketPeer) Recv() <-chan wamp.Message { return p.rd }
+
+func (p *rawSocketPeer) IsLocal() bool { return false }
+
+// rawSocketFrame is a serialized message waiting to be written.
+type rawSocketFrame struct {
+	msg  wamp.Message
+	data []byte
+}
+
+// frame serializes msg, failing if the router would not accept it.
+func (p *rawSocketPeer) frame(msg wamp.Message) (rawSocketFrame, error) {
+	data, err := p.serializer.Serialize(msg)
+	if err != nil {
+		return rawSocketFrame{}, fmt.Errorf("failed to serialize %s: %w", msg.MessageType(), err)
+	}
+	if len(data) > p.sendLimit {
+		return rawSocketFrame{}, fmt.Errorf("%s of %d bytes exceeds the router's limit of %d bytes",
+			msg.MessageType(), len(data), p.sendLimit)
+	}
+	return rawSocketFrame{msg: msg, data: data}, nil
+}
+
+func (p *rawSocketPeer) TrySend(msg wamp.Message) error {
+	frame, err := p.frame(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case p.wr <- frame:
+		return nil
+	default:
+		return errors.New("blocked")
+	}
+}
+
+func (p *rawSocketPeer) SendCtx(ctx context.Context, msg wamp.Message) error {
+	frame, err := p.frame(msg)
+	if err != nil {
+		return err
+	}
+	select {
+	case p.wr <- frame:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *rawSocketPeer) Send(msg wamp.Message) error {
+	return p.SendCtx(p.ctx, msg)
+}
+
+// Close stops sending, discarding queued messages, and closes the
+// connection.
+func (p *rawSocketPeer) Close() {
+	p.closeOnce.Do(func() {
+		p.cancel()
+		<-p.writerDone
+		p.conn.Close()
+	})
+}
+
+func (p *rawSocketPeer) sendHandler() {
+	defer close(p.writerDone)
+	for {
+		select {
+		case frame := <-p.wr:
+			err := p.writeFrame(rawSocketMessage, frame.data)
+			if err != nil && !wamp.IsGoodbyeAck(frame.msg) {
+				logger.Errorf("Failed to send %s: %s", frame.msg.MessageType(), err)
+			}
+		case <-p.ctx.Done():
+			return
+		}
+	}
+}
+
+func (p *rawSocketPeer) writeFrame(frameType byte, data []byte) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	length := len(data)
+	header := []byte{frameType, byte(length >> 16), byte(length >> 8), byte(length)}
+	if _, err := p.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := p.conn.Write(data)
+	return err
+}
+
+func (p *rawSocketPeer) recvHandler() {
+	defer close(p.rd)
+	defer p.cancel()
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(p.conn, header[:]); err != nil {
+			return
+		}
+		length := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		if length > p.recvLimit {
+			logger.Errorf("Received message of %d bytes, exceeding the limit of %d bytes, closing",
+				length, p.recvLimit)
+			p.conn.Close()
+			return
+		}
+
+		switch header[0] & 0x7 {
+		case rawSocketMessage:
+			data := make([]byte, length)
+			if _, err := io.ReadFull(p.conn, data); err != nil {
+				return
+			}
+			msg, err := p.serializer.Deserialize(data)
+			if err != nil {
+				logger.Errorf("Failed to deserialize message: %s", err)
+				continue
+			}
+			select {
+			case p.rd <- msg:
+			case <-p.ctx.Done():
+				return
+			}
+		case rawSocketPing:
+			data := make([]byte, length)
+			if _, err := io.ReadFull(p.conn, data); err != nil {
+				return
+			}
+			if err := p.writeFrame(rawSocketPong, data); err != nil {
+				return
+			}
+		default:
+			if _, err := io.CopyN(ioutil.Discard, p.conn, int64(length)); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestRawSocketFrameLimit(t *testing.T) {
+	p := &rawSocketPeer{serializer: &serialize.JSONSerializer{}, sendLimit: rawSocketLength(0xf)}
+	publish := func(size int) *wamp.Publish {
+		return &wamp.Publish{Request: 1, Topic: "foo", Arguments: wamp.List{strings.Repeat("a", size)}}
+	}
+	empty, err := p.serializer.Serialize(publish(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The frame header holds lengths below 16 MB.
+	if _, err = p.frame(publish(1<<24 - 1 - len(empty))); err != nil {
+		t.Errorf("expected the largest frame to be accepted: %s", err)
+	}
+	if _, err = p.frame(publish(1<<24 - len(empty))); err == nil {
+		t.Error("expected a 16 MB message to be rejected")
+	}
+}
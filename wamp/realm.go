@@ -0,0 +1,95 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DefaultManagementPrefix is the URI prefix of the management procedures of
+// the default router worker of a Crossbar node.
+const DefaultManagementPrefix = "crossbar.worker.worker001"
+
+// allowAll is the permission given to roles created with CreateRealm.
+var allowAll = wamp.Dict{
+	"uri":      "",
+	"match":    wamp.MatchPrefix,
+	"allow":    wamp.Dict{"call": true, "register": true, "publish": true, "subscribe": true},
+	"disclose": wamp.Dict{"caller": false, "publisher": false},
+	"cache":    true,
+}
+
+// ListRealms writes the realms started on the router worker under prefix
+// to output, one per line.
+func ListRealms(session *client.Client, prefix string, output io.Writer) error {
+	result, err := callManagement(session, prefix, "get_router_realms", nil)
+	if err != nil {
+		return err
+	}
+	if len(result.Arguments) == 0 {
+		return nil
+	}
+	realms, _ := wamp.AsList(result.Arguments[0])
+	for _, realm := range realms {
+		fmt.Fprintln(output, realm)
+	}
+	return nil
+}
+
+// CreateRealm starts realm on the router worker under prefix, with roles
+// that are allowed every operation on every URI.
+func CreateRealm(session *client.Client, prefix string, realm string, roles []string) error {
+	if _, err := callManagement(session, prefix, "start_router_realm",
+		wamp.List{realm, wamp.Dict{"name": realm}}); err != nil {
+		return err
+	}
+	for _, role := range roles {
+		config := wamp.Dict{"name": role, "permissions": wamp.List{allowAll}}
+		if _, err := callManagement(session, prefix, "start_router_realm_role",
+			wamp.List{realm, role, config}); err != nil {
+			return err
+		}
+	}
+	logger.Printf("Created realm '%s'\n", realm)
+	return nil
+}
+
+// DeleteRealm stops realm on the router worker under prefix.
+func DeleteRealm(session *client.Client, prefix string, realm string) error {
+	if _, err := callManagement(session, prefix, "stop_router_realm", wamp.List{realm}); err != nil {
+		return err
+	}
+	logger.Printf("Deleted realm '%s'\n", realm)
+	return nil
+}
+
+func callManagement(session *client.Client, prefix string, procedure string, args wamp.List) (*wamp.Result, error) {
+	return session.Call(context.Background(), prefix+"."+procedure, nil, args, nil, nil)
+}
@@ -0,0 +1,288 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// DefaultMaxReconnectBackoff is the longest wait between attempts to
+// reconnect by default.
+const DefaultMaxReconnectBackoff = 30 * time.Second
+
+// progressReconnecting is the progress event of every attempt to reconnect.
+const progressReconnecting = "reconnecting"
+
+const minReconnectBackoff = time.Second
+
+var (
+	reconnectEnabled    bool
+	maxReconnectBackoff = DefaultMaxReconnectBackoff
+
+	// sessionDialers maps sessions to the function that opens a new session
+	// the same way.
+	sessionDialers sync.Map
+	// reconnectingSessions maps the sessions wrapped by a ReconnectingSession
+	// to it, so others can follow it to the current session.
+	reconnectingSessions sync.Map
+)
+
+// EnableReconnect makes Subscribe and Register reconnect when the session is
+// lost, waiting up to maxBackoff between attempts, and subscribe and register
+// again on the new session.
+func EnableReconnect(maxBackoff time.Duration) {
+	reconnectEnabled = true
+	if maxBackoff >= minReconnectBackoff {
+		maxReconnectBackoff = maxBackoff
+	}
+}
+
+type subscription struct {
+	topic   string
+	handler client.EventHandler
+	options wamp.Dict
+}
+
+type registration struct {
+	procedure string
+	handler   client.InvocationHandler
+	options   wamp.Dict
+}
+
+// ReconnectingSession keeps a session to the router open. When the session
+// is lost, it redials with exponential backoff and replays its subscriptions,
+// registrations and testaments on the new session.
+type ReconnectingSession struct {
+	dial       func() (*client.Client, error)
+	maxBackoff time.Duration
+
+	mu            sync.Mutex
+	session       *client.Client
+	subscriptions []subscription
+	registrations []registration
+	testaments    []addedTestament
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// NewReconnectingSession wraps session, which must have been opened with
+// one of the Connect functions, waiting up to maxBackoff between attempts
+// to reconnect.
+func NewReconnectingSession(session *client.Client, maxBackoff time.Duration) (*ReconnectingSession, error) {
+	value, ok := sessionDialers.Load(session)
+	if !ok {
+		return nil, errors.New("session was not opened by wick, can't reconnect it")
+	}
+	if maxBackoff < minReconnectBackoff {
+		maxBackoff = minReconnectBackoff
+	}
+
+	r := &ReconnectingSession{
+		dial:       value.(func() (*client.Client, error)),
+		maxBackoff: maxBackoff,
+		session:    session,
+		testaments: takeTestaments(session),
+		done:       make(chan struct{}),
+	}
+	reconnectingSessions.Store(session, r)
+	go r.keepAlive()
+	return r, nil
+}
+
+// reconnecting returns a ReconnectingSession for session if EnableReconnect
+// was called, or nil.
+func reconnecting(session *client.Client) *ReconnectingSession {
+	if !reconnectEnabled {
+		return nil
+	}
+	r, err := NewReconnectingSession(session, maxReconnectBackoff)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return r
+}
+
+// liveSession returns the current session of the ReconnectingSession
+// wrapping session, or session itself.
+func liveSession(session *client.Client) *client.Client {
+	if value, ok := reconnectingSessions.Load(session); ok {
+		return value.(*ReconnectingSession).Client()
+	}
+	return session
+}
+
+// Client returns the current session.
+func (r *ReconnectingSession) Client() *client.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session
+}
+
+// Done is closed when the ReconnectingSession is closed.
+func (r *ReconnectingSession) Done() <-chan struct{} {
+	return r.done
+}
+
+// Close closes the current session and stops reconnecting.
+func (r *ReconnectingSession) Close() error {
+	r.closeOnce.Do(func() {
+		close(r.done)
+	})
+	return r.Client().Close()
+}
+
+// Subscribe subscribes to topic, now and after every reconnect.
+func (r *ReconnectingSession) Subscribe(topic string, handler client.EventHandler, options wamp.Dict) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.session.Subscribe(topic, handler, options); err != nil {
+		return err
+	}
+	r.subscriptions = append(r.subscriptions, subscription{topic: topic, handler: handler, options: options})
+	return nil
+}
+
+// Unsubscribe unsubscribes from topic and stops subscribing to it again.
+func (r *ReconnectingSession) Unsubscribe(topic string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, s := range r.subscriptions {
+		if s.topic == topic {
+			r.subscriptions = append(r.subscriptions[:i], r.subscriptions[i+1:]...)
+			break
+		}
+	}
+	return r.session.Unsubscribe(topic)
+}
+
+// Register registers procedure, now and after every reconnect.
+func (r *ReconnectingSession) Register(procedure string, handler client.InvocationHandler,
+	options wamp.Dict) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.session.Register(procedure, handler, options); err != nil {
+		return err
+	}
+	r.registrations = append(r.registrations, registration{procedure: procedure, handler: handler,
+		options: options})
+	return nil
+}
+
+// Unregister unregisters procedure and stops registering it again.
+func (r *ReconnectingSession) Unregister(procedure string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, reg := range r.registrations {
+		if reg.procedure == procedure {
+			r.registrations = append(r.registrations[:i], r.registrations[i+1:]...)
+			break
+		}
+	}
+	return r.session.Unregister(procedure)
+}
+
+// keepAlive reconnects whenever the current session is lost, until closed.
+func (r *ReconnectingSession) keepAlive() {
+	for {
+		select {
+		case <-r.Client().Done():
+		case <-r.done:
+			return
+		}
+
+		session := r.reconnect()
+		if session == nil {
+			return
+		}
+		r.mu.Lock()
+		reconnectingSessions.Delete(r.session)
+		r.session = session
+		reconnectingSessions.Store(session, r)
+		r.replay()
+		r.mu.Unlock()
+		notifyReconnect(session)
+	}
+}
+
+// reconnect dials until it gets a new session, doubling the wait between
+// attempts up to maxBackoff. It returns nil if closed meanwhile.
+func (r *ReconnectingSession) reconnect() *client.Client {
+	logger.Warn("Connection to router lost")
+	backoff := minReconnectBackoff
+	for attempt := 1; ; attempt++ {
+		logger.Printf("Reconnecting in %s (attempt %d)\n", backoff, attempt)
+		emitProgress(progressReconnecting, map[string]interface{}{"attempt": attempt,
+			"delay": backoff.String()})
+		select {
+		case <-time.After(backoff):
+		case <-r.done:
+			return nil
+		}
+
+		session, err := r.dial()
+		if err == nil {
+			logger.Printf("Reconnected with session %d\n", session.ID())
+			return session
+		}
+		logger.Warnf("Failed to reconnect: %s\n", err)
+		if backoff *= 2; backoff > r.maxBackoff {
+			backoff = r.maxBackoff
+		}
+	}
+}
+
+// replay subscribes, registers and adds testaments again on the current
+// session. Failures are logged, the session is kept for the others.
+func (r *ReconnectingSession) replay() {
+	for _, s := range r.subscriptions {
+		err := r.session.Subscribe(s.topic, s.handler, s.options)
+		emitProgress(progressSubscribed, withURI(progressStatus(err), s.topic))
+		if err != nil {
+			logger.Errorf("Failed to subscribe to '%s' again: %s\n", s.topic, err)
+		} else {
+			logger.Printf("Subscribed to topic '%s' again\n", s.topic)
+		}
+	}
+	for _, reg := range r.registrations {
+		err := r.session.Register(reg.procedure, reg.handler, reg.options)
+		emitProgress(progressRegistered, withURI(progressStatus(err), reg.procedure))
+		if err != nil {
+			logger.Errorf("Failed to register '%s' again: %s\n", reg.procedure, err)
+		} else {
+			logger.Printf("Registered procedure '%s' again\n", reg.procedure)
+		}
+	}
+	for _, added := range r.testaments {
+		if err := added.add(r.session); err != nil {
+			logger.Errorf("Failed to add testament again: %s\n", err)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// PublishRepeated publishes to topic repeat times, evaluating templates in
+// the topic and arguments anew for every publish, and preparing the payload
+// only once if there are none. In the topic, {{i}} is the index of the
+// publish, so events can be spread over many topics. The rows set with
+// SetDataRows are sent in turn. With ticks enabled, each publish waits for a
+// tick. Ctrl-C stops before the remaining publishes.
+func PublishRepeated(session *client.Client, topic string, args []string, kwargs map[string]string,
+	options map[string]string, repeat int) {
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	defer signal.Stop(sigChan)
+
+	var firstErr error
+	var sentArgs wamp.List
+	var sentKwargs wamp.Dict
+	published, failed := 0, 0
+	// Without templates or data rows every publish is the same, so the
+	// payload is only prepared once.
+	reusable := !hasTemplates(topic, args, kwargs, options) && len(dataRows) == 0
+	var reused *publishPayload
+	bar := newProgressBar(repeat, "publishes")
+publishing:
+	for i := 0; i < repeat; i++ {
+		if stopped, ended := nextTick(sigChan); stopped {
+			logger.Warnf("Interrupted, %d publishes not made\n", repeat-i)
+			break publishing
+		} else if ended {
+			logger.Warnf("Ticks ended, %d publishes not made\n", repeat-i)
+			break publishing
+		}
+
+		Jitter()
+		iterationTopic := expandIteration(topic, i)
+		checkURI("publish", iterationTopic)
+		start := time.Now()
+		var payload publishPayload
+		var err error
+		if reused != nil {
+			payload = *reused
+		} else if payload, err = preparePublish(iterationTopic, args, kwargs, options, dataRow(i)); err == nil &&
+			reusable {
+			reused = &payload
+		}
+		var publication wamp.ID
+		if err == nil {
+			publication, err = payload.send(session, iterationTopic)
+		}
+		correlation := payload.correlation
+		recordMeasurement(start, payload.args, payload.kwargs, err)
+		if i == 0 {
+			// The history holds the payload of the first publish, as
+			// templates make every publish's different.
+			sentArgs, sentKwargs = payload.args, payload.kwargs
+		}
+		published++
+		bar.add(err)
+		if err != nil {
+			failed++
+			if firstErr == nil {
+				firstErr = err
+			}
+			bar.print(func() {
+				logger.Printf("Publish to '%s' failed%s: %s\n", iterationTopic, correlation, err)
+			})
+			continue
+		}
+		logger.Debugf("Published to topic '%s' with publication id %d%s\n", iterationTopic, publication,
+			correlation)
+	}
+
+	bar.finish()
+	if published > 0 {
+		recordHistory("publish", topic, sentArgs, sentKwargs, firstErr)
+	}
+	logger.Printf("Published to topic '%s' %d times, %d failed\n", topic, published, failed)
+}
+
+// hasTemplates reports whether topic, args or the values of kwargs or
+// options hold a template.
+func hasTemplates(topic string, args []string, kwargs map[string]string, options map[string]string) bool {
+	values := append([]string{topic}, args...)
+	for _, value := range kwargs {
+		values = append(values, value)
+	}
+	for _, value := range options {
+		values = append(values, value)
+	}
+	for _, value := range values {
+		if strings.Contains(value, "{{") {
+			return true
+		}
+	}
+	return false
+}
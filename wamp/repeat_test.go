@@ -0,0 +1,50 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import "testing"
+
+func TestHasTemplates(t *testing.T) {
+	tests := []struct {
+		name     string
+		topic    string
+		args     []string
+		kwargs   map[string]string
+		options  map[string]string
+		expected bool
+	}{
+		{"static", "com.example.topic", []string{"1", "a"}, map[string]string{"k": "v"},
+			map[string]string{"exclude_me": "false"}, false},
+		{"topic", "com.example.{{i}}", nil, nil, nil, true},
+		{"arg", "com.example.topic", []string{"{{uuid}}"}, nil, nil, true},
+		{"kwarg", "com.example.topic", nil, map[string]string{"at": "{{now_iso}}"}, nil, true},
+		{"option", "com.example.topic", nil, nil, map[string]string{"eligible": "{{env \"ID\"}}"}, true},
+	}
+	for _, test := range tests {
+		if templates := hasTemplates(test.topic, test.args, test.kwargs, test.options); templates != test.expected {
+			t.Errorf("%s: got %v, expected %v", test.name, templates, test.expected)
+		}
+	}
+}
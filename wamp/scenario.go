@@ -0,0 +1,563 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// scenarioFileOptions are the dialect of scenarios. They are scripts rather
+// than configuration, so top-level loops and conditionals, reassignment of
+// globals, recursion, while loops and sets are allowed.
+var scenarioFileOptions = &syntax.FileOptions{Set: true, GlobalReassign: true, TopLevelControl: true,
+	While: true, Recursion: true}
+
+// scenario holds the state of a running Starlark script. Starlark values are
+// not safe for concurrent use, so every piece of script code (the top level
+// as well as event and invocation handlers) runs while holding mu. Builtins
+// that block on the network or on time release it so handlers can run.
+//
+// Event handlers are queued on events and run by their own goroutine, since
+// the session delivers events on the goroutine that receives the replies
+// that call() and publish() in those handlers wait for.
+type scenario struct {
+	mu      sync.Mutex
+	session *client.Client
+	tag     string
+	log     *logrus.Entry
+	events  chan func()
+	done    chan struct{}
+
+	topics     []string
+	procedures []string
+}
+
+// RunScenario executes the Starlark script at path. The script drives the
+// given session through the call, publish, subscribe and register builtins.
+// The instance index is exposed to the script as the "instance" global, so
+// that concurrent copies of a scenario can use distinct URIs or payloads.
+// If tag is not empty, output printed by the script is prefixed with it.
+// Log entries carry the instance and the authid of the session as fields.
+func RunScenario(session *client.Client, path string, instance int, tag string) error {
+	authid, _ := wamp.AsString(session.RealmDetails()["authid"])
+	s := &scenario{session: session, tag: tag, events: make(chan func(), forwardQueueSize),
+		done: make(chan struct{})}
+	s.log = logger.WithFields(logrus.Fields{"instance": instance, "authid": authid})
+	go s.dispatchEvents()
+	defer close(s.done)
+
+	predeclared := starlark.StringDict{
+		"call":      starlark.NewBuiltin("call", s.call),
+		"publish":   starlark.NewBuiltin("publish", s.publish),
+		"subscribe": starlark.NewBuiltin("subscribe", s.subscribe),
+		"register":  starlark.NewBuiltin("register", s.register),
+		"sleep":     starlark.NewBuiltin("sleep", s.sleep),
+		"wait":      starlark.NewBuiltin("wait", s.wait),
+		"struct":    starlark.NewBuiltin("struct", starlarkstruct.Make),
+		"instance":  starlark.MakeInt(instance),
+	}
+
+	thread := &starlark.Thread{Name: path, Print: s.print}
+
+	s.mu.Lock()
+	_, err := starlark.ExecFileOptions(scenarioFileOptions, thread, path, nil, predeclared)
+	s.mu.Unlock()
+
+	for _, topic := range s.topics {
+		if errUnsub := session.Unsubscribe(topic); errUnsub != nil {
+			s.log.Println("Failed to unsubscribe:", errUnsub)
+		}
+	}
+	for _, procedure := range s.procedures {
+		if errUnreg := session.Unregister(procedure); errUnreg != nil {
+			s.log.Println("Failed to unregister procedure:", errUnreg)
+		}
+	}
+
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return err
+}
+
+// dispatchEvents runs queued event handlers in the order the events were
+// received, until the scenario ends.
+func (s *scenario) dispatchEvents() {
+	for {
+		select {
+		case handle := <-s.events:
+			handle()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func (s *scenario) print(_ *starlark.Thread, msg string) {
+	if s.tag != "" {
+		fmt.Printf("[%s] %s\n", s.tag, msg)
+	} else {
+		fmt.Println(msg)
+	}
+}
+
+// unlocked runs fn with the script lock released, so that handlers can be
+// executed while the calling thread blocks.
+func (s *scenario) unlocked(fn func()) {
+	s.mu.Unlock()
+	defer s.mu.Lock()
+	fn()
+}
+
+func (s *scenario) call(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing procedure", b.Name())
+	}
+	procedure, ok := starlark.AsString(args[0])
+	if !ok {
+		return nil, fmt.Errorf("%s: procedure must be a string, got %s", b.Name(), args[0].Type())
+	}
+
+	arguments, keywordArguments, err := fromStarlarkArgs(args[1:], kwargs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	var result *wamp.Result
+	s.unlocked(func() {
+		result, err = s.session.Call(context.Background(), procedure, nil, arguments, keywordArguments, nil)
+	})
+	emitProgress(progressCalled, withURI(progressStatus(err), procedure))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	return newScenarioResult(result.Arguments, result.ArgumentsKw, result.Details)
+}
+
+func (s *scenario) publish(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%s: missing topic", b.Name())
+	}
+	topic, ok := starlark.AsString(args[0])
+	if !ok {
+		return nil, fmt.Errorf("%s: topic must be a string, got %s", b.Name(), args[0].Type())
+	}
+
+	arguments, keywordArguments, err := fromStarlarkArgs(args[1:], kwargs)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	var publication wamp.ID
+	s.unlocked(func() {
+		publication, err = publishAcknowledged(s.session, topic, nil, arguments, keywordArguments)
+	})
+	emitProgress(progressPublished, withURI(progressStatus(err), topic))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+
+	return starlark.MakeUint64(uint64(publication)), nil
+}
+
+func (s *scenario) subscribe(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var topic string
+	var handler starlark.Callable
+	match := wamp.MatchExact
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "topic", &topic, "handler", &handler,
+		"match?", &match); err != nil {
+		return nil, err
+	}
+
+	eventHandler := func(event *wamp.Event) {
+		handle := func() {
+			value, err := newScenarioResult(event.Arguments, event.ArgumentsKw, event.Details)
+			if err != nil {
+				s.log.Println("event conversion error:", err)
+				return
+			}
+			s.invoke(thread.Name, handler, starlark.Tuple{value})
+		}
+		select {
+		case s.events <- handle:
+		default:
+			s.log.Warnf("Dropped event on '%s': %d events are waiting to be handled\n", topic,
+				forwardQueueSize)
+		}
+	}
+
+	var err error
+	options := wamp.Dict{wamp.OptMatch: match}
+	s.unlocked(func() {
+		err = s.session.Subscribe(topic, eventHandler, options)
+	})
+	emitProgress(progressSubscribed, withURI(progressStatus(err), topic))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	s.topics = append(s.topics, topic)
+
+	return starlark.None, nil
+}
+
+func (s *scenario) register(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var procedure string
+	var handler starlark.Callable
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "procedure", &procedure, "handler", &handler); err != nil {
+		return nil, err
+	}
+
+	invocationHandler := func(ctx context.Context, inv *wamp.Invocation) client.InvokeResult {
+		callArgs, callKwargs, err := toStarlarkArgs(inv.Arguments, inv.ArgumentsKw)
+		if err != nil {
+			s.log.Println("invocation conversion error:", err)
+			return client.InvokeResult{Err: wamp.ErrInvalidArgument}
+		}
+
+		value, err := s.invokeKw(thread.Name, handler, callArgs, callKwargs)
+		if err != nil {
+			return client.InvokeResult{Err: errRuntime, Args: wamp.List{err.Error()}}
+		}
+
+		s.mu.Lock()
+		result, err := fromStarlark(value)
+		s.mu.Unlock()
+		if err != nil {
+			return client.InvokeResult{Err: errRuntime, Args: wamp.List{err.Error()}}
+		}
+		if result == nil {
+			return client.InvokeResult{}
+		}
+		return client.InvokeResult{Args: wamp.List{result}}
+	}
+
+	var err error
+	s.unlocked(func() {
+		err = s.session.Register(procedure, invocationHandler, nil)
+	})
+	emitProgress(progressRegistered, withURI(progressStatus(err), procedure))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	s.procedures = append(s.procedures, procedure)
+
+	return starlark.None, nil
+}
+
+func (s *scenario) sleep(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var seconds starlark.Value
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &seconds); err != nil {
+		return nil, err
+	}
+	duration, ok := starlark.AsFloat(seconds)
+	if !ok {
+		return nil, fmt.Errorf("%s: seconds must be a number, got %s", b.Name(), seconds.Type())
+	}
+
+	s.unlocked(func() {
+		time.Sleep(time.Duration(duration * float64(time.Second)))
+	})
+
+	return starlark.None, nil
+}
+
+// wait blocks until CTRL-c, the router going away or the optional timeout,
+// handling events and invocations meanwhile.
+func (s *scenario) wait(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	var timeout starlark.Value = starlark.None
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+
+	var timer <-chan time.Time
+	if timeout != starlark.None {
+		seconds, ok := starlark.AsFloat(timeout)
+		if !ok {
+			return nil, fmt.Errorf("%s: timeout must be a number, got %s", b.Name(), timeout.Type())
+		}
+		timer = time.After(time.Duration(seconds * float64(time.Second)))
+	}
+
+	s.unlocked(func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, os.Interrupt)
+		defer signal.Stop(sigChan)
+		select {
+		case <-sigChan:
+		case <-timer:
+		case <-s.session.Done():
+			s.log.Print("Router gone, exiting")
+		}
+	})
+
+	return starlark.None, nil
+}
+
+func (s *scenario) invoke(name string, handler starlark.Callable, args starlark.Tuple) {
+	if _, err := s.invokeKw(name, handler, args, nil); err != nil {
+		s.log.Println(err)
+	}
+}
+
+func (s *scenario) invokeKw(name string, handler starlark.Callable, args starlark.Tuple,
+	kwargs []starlark.Tuple) (starlark.Value, error) {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	thread := &starlark.Thread{Name: name, Print: s.print}
+	value, err := starlark.Call(thread, handler, args, kwargs)
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return nil, fmt.Errorf("%s", evalErr.Backtrace())
+	}
+	return value, err
+}
+
+// newScenarioResult wraps a WAMP payload into a struct with args, kwargs and
+// details fields, as handed to Starlark handlers and returned from call().
+func newScenarioResult(args wamp.List, kwargs wamp.Dict, details wamp.Dict) (starlark.Value, error) {
+	starlarkArgs, err := toStarlark(args)
+	if err != nil {
+		return nil, err
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+	starlarkKwargs, err := toStarlark(kwargs)
+	if err != nil {
+		return nil, err
+	}
+	if details == nil {
+		details = wamp.Dict{}
+	}
+	starlarkDetails, err := toStarlark(details)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"args":    starlarkArgs,
+		"kwargs":  starlarkKwargs,
+		"details": starlarkDetails,
+	}), nil
+}
+
+func fromStarlarkArgs(args starlark.Tuple, kwargs []starlark.Tuple) (wamp.List, wamp.Dict, error) {
+	arguments := wamp.List{}
+	for _, arg := range args {
+		value, err := fromStarlark(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	keywordArguments := wamp.Dict{}
+	for _, kwarg := range kwargs {
+		key, _ := starlark.AsString(kwarg[0])
+		value, err := fromStarlark(kwarg[1])
+		if err != nil {
+			return nil, nil, err
+		}
+		keywordArguments[key] = value
+	}
+
+	return arguments, keywordArguments, nil
+}
+
+func toStarlarkArgs(args wamp.List, kwargs wamp.Dict) (starlark.Tuple, []starlark.Tuple, error) {
+	var arguments starlark.Tuple
+	for _, arg := range args {
+		value, err := toStarlark(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		arguments = append(arguments, value)
+	}
+
+	keys := make([]string, 0, len(kwargs))
+	for key := range kwargs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var keywordArguments []starlark.Tuple
+	for _, key := range keys {
+		value, err := toStarlark(kwargs[key])
+		if err != nil {
+			return nil, nil, err
+		}
+		keywordArguments = append(keywordArguments, starlark.Tuple{starlark.String(key), value})
+	}
+
+	return arguments, keywordArguments, nil
+}
+
+func fromStarlark(value starlark.Value) (interface{}, error) {
+	switch v := value.(type) {
+	case starlark.NoneType:
+		return nil, nil
+	case starlark.Bool:
+		return bool(v), nil
+	case starlark.Int:
+		if i, ok := v.Int64(); ok {
+			return i, nil
+		}
+		return nil, fmt.Errorf("integer %s out of range", v)
+	case starlark.Float:
+		return float64(v), nil
+	case starlark.String:
+		return string(v), nil
+	case starlark.Bytes:
+		return []byte(v), nil
+	case *starlark.List:
+		list := make(wamp.List, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			item, err := fromStarlark(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case starlark.Tuple:
+		list := make(wamp.List, 0, len(v))
+		for _, elem := range v {
+			item, err := fromStarlark(elem)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, nil
+	case *starlark.Dict:
+		dict := wamp.Dict{}
+		for _, item := range v.Items() {
+			key, ok := starlark.AsString(item[0])
+			if !ok {
+				return nil, fmt.Errorf("dict keys must be strings, got %s", item[0].Type())
+			}
+			elem, err := fromStarlark(item[1])
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = elem
+		}
+		return dict, nil
+	case *starlarkstruct.Struct:
+		fields := starlark.StringDict{}
+		v.ToStringDict(fields)
+		dict := wamp.Dict{}
+		for key, field := range fields {
+			elem, err := fromStarlark(field)
+			if err != nil {
+				return nil, err
+			}
+			dict[key] = elem
+		}
+		return dict, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %s to a WAMP value", value.Type())
+}
+
+func toStarlark(value interface{}) (starlark.Value, error) {
+	switch v := value.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []byte:
+		return starlark.Bytes(v), nil
+	case float32:
+		return starlark.Float(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	}
+
+	if i, ok := wamp.AsInt64(value); ok {
+		return starlark.MakeInt64(i), nil
+	}
+	if list, ok := wamp.AsList(value); ok {
+		elems := make([]starlark.Value, 0, len(list))
+		for _, item := range list {
+			elem, err := toStarlark(item)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, elem)
+		}
+		return starlark.NewList(elems), nil
+	}
+	if dict, ok := wamp.AsDict(value); ok {
+		keys := make([]string, 0, len(dict))
+		for key := range dict {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		result := starlark.NewDict(len(dict))
+		for _, key := range keys {
+			elem, err := toStarlark(dict[key])
+			if err != nil {
+				return nil, err
+			}
+			if err = result.SetKey(starlark.String(key), elem); err != nil {
+				return nil, err
+			}
+		}
+		return result, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to a Starlark value", value)
+}
@@ -0,0 +1,107 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var schemaDir string
+
+// EnableValidation makes call and publish validate their payload against the
+// JSON schema in dir named after the procedure or topic URI, e.g.
+// com.example.add.json. The schema is applied to an object of the form
+// {"args": [...], "kwargs": {...}}. URIs without a schema are not validated.
+func EnableValidation(dir string) {
+	schemaDir = dir
+}
+
+// validatePayload checks args and kwargs against the schema for uri, if
+// validation is enabled and such a schema exists.
+func validatePayload(uri string, args wamp.List, kwargs wamp.Dict) error {
+	if schemaDir == "" {
+		return nil
+	}
+
+	path := filepath.Join(schemaDir, uri+".json")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		logger.Debugf("No schema for '%s', not validating\n", uri)
+		return nil
+	}
+
+	schema, err := jsonschema.Compile(path)
+	if err != nil {
+		return fmt.Errorf("invalid schema for '%s': %v", uri, err)
+	}
+
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+
+	// Round trip through JSON so the validator sees plain JSON values.
+	payload, err := json.Marshal(map[string]interface{}{"args": args, "kwargs": kwargs})
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var document interface{}
+	if err = decoder.Decode(&document); err != nil {
+		return err
+	}
+
+	if err = schema.Validate(document); err != nil {
+		validationErr, ok := err.(*jsonschema.ValidationError)
+		if !ok {
+			return err
+		}
+		var problems []string
+		for _, basicErr := range validationErr.BasicOutput().Errors {
+			if basicErr.Error == "" || strings.HasPrefix(basicErr.Error, "doesn't validate with") {
+				continue
+			}
+			location := basicErr.InstanceLocation
+			if location == "" {
+				location = "/"
+			}
+			problems = append(problems, fmt.Sprintf("%s: %s", location, basicErr.Error))
+		}
+		return fmt.Errorf("payload for '%s' does not match schema %s: %s", uri, path,
+			strings.Join(problems, "; "))
+	}
+
+	return nil
+}
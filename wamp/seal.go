@@ -0,0 +1,137 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+const nonceSize = 24
+
+var (
+	sealedKwargs map[string]bool
+	sealKey      [32]byte
+)
+
+// EnableSealing encrypts the values of the named kwargs with NaCl secretbox
+// before they are sent, and decrypts them when received. key is the 32 byte
+// secret key in hex.
+func EnableSealing(kwargs []string, key string) error {
+	decoded, err := hex.DecodeString(key)
+	if err != nil || len(decoded) != len(sealKey) {
+		return errors.New("seal key must be 32 bytes in hex (64 characters)")
+	}
+	copy(sealKey[:], decoded)
+
+	sealedKwargs = map[string]bool{}
+	for _, kwarg := range kwargs {
+		sealedKwargs[kwarg] = true
+	}
+	return nil
+}
+
+// sealKwargs replaces the values of the sealed kwargs with the base64 of
+// the nonce followed by the secretbox of their JSON encoding.
+func sealKwargs(kwargs wamp.Dict) {
+	for key, value := range kwargs {
+		if !sealedKwargs[key] {
+			continue
+		}
+
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			logger.Fatalf("Failed to seal kwarg '%s': %s", key, err)
+		}
+		var nonce [nonceSize]byte
+		if _, err = rand.Read(nonce[:]); err != nil {
+			logger.Fatal(err)
+		}
+		sealed := secretbox.Seal(nonce[:], plaintext, &nonce, &sealKey)
+		kwargs[key] = base64.StdEncoding.EncodeToString(sealed)
+	}
+}
+
+// unsealKwargs returns a copy of kwargs with the sealed kwargs decrypted.
+// Values that cannot be decrypted are kept as they are.
+func unsealKwargs(kwargs wamp.Dict) wamp.Dict {
+	if len(sealedKwargs) == 0 || len(kwargs) == 0 {
+		return kwargs
+	}
+
+	unsealed := make(wamp.Dict, len(kwargs))
+	for key, value := range kwargs {
+		unsealed[key] = value
+		if !sealedKwargs[key] {
+			continue
+		}
+		plain, err := unseal(value)
+		if err != nil {
+			logger.Warnf("Failed to unseal kwarg '%s': %s", key, err)
+			continue
+		}
+		unsealed[key] = plain
+	}
+	return unsealed
+}
+
+// UnsealValue decrypts a value sealed with the key given to EnableSealing.
+func UnsealValue(value interface{}) (interface{}, error) {
+	return unseal(value)
+}
+
+func unseal(value interface{}) (interface{}, error) {
+	encoded, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, got %T", value)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < nonceSize+secretbox.Overhead {
+		return nil, errors.New("sealed value too short")
+	}
+
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[:nonceSize])
+	plaintext, ok := secretbox.Open(nil, sealed[nonceSize:], &nonce, &sealKey)
+	if !ok {
+		return nil, errors.New("decryption failed, wrong key?")
+	}
+
+	var result interface{}
+	if err = json.Unmarshal(plaintext, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
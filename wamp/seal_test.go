@@ -0,0 +1,141 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	testSealKey  = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	otherSealKey = "1f1e1d1c1b1a191817161514131211100f0e0d0c0b0a09080706050403020100"
+)
+
+func enableTestSealing(t *testing.T, kwargs []string, key string) {
+	t.Helper()
+	if err := EnableSealing(kwargs, key); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sealedKwargs = nil
+		sealKey = [32]byte{}
+	})
+}
+
+func TestSealRoundTrip(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"string", "s3cret"},
+		{"number", 42.5},
+		{"bool", true},
+		{"null", nil},
+		{"list", []interface{}{"a", 1.0}},
+		{"object", map[string]interface{}{"card": "4111", "cvv": 123.0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			enableTestSealing(t, []string{"secret"}, testSealKey)
+
+			kwargs := wamp.Dict{"secret": test.value, "plain": "visible"}
+			sealKwargs(kwargs)
+			if reflect.DeepEqual(kwargs["secret"], test.value) {
+				t.Fatal("sealed kwarg sent in the clear")
+			}
+			if kwargs["plain"] != "visible" {
+				t.Errorf("kwarg that isn't sealed changed to %v", kwargs["plain"])
+			}
+
+			unsealed := unsealKwargs(kwargs)
+			if !reflect.DeepEqual(unsealed["secret"], test.value) {
+				t.Errorf("unsealed %#v, expected %#v", unsealed["secret"], test.value)
+			}
+			if unsealed["plain"] != "visible" {
+				t.Errorf("kwarg that isn't sealed changed to %v", unsealed["plain"])
+			}
+		})
+	}
+}
+
+func TestSealNonceIsRandom(t *testing.T) {
+	enableTestSealing(t, []string{"secret"}, testSealKey)
+
+	first := wamp.Dict{"secret": "same"}
+	second := wamp.Dict{"secret": "same"}
+	sealKwargs(first)
+	sealKwargs(second)
+	if first["secret"] == second["secret"] {
+		t.Error("sealing the same value twice gave the same ciphertext")
+	}
+}
+
+func TestUnsealWrongKey(t *testing.T) {
+	enableTestSealing(t, []string{"secret"}, testSealKey)
+	kwargs := wamp.Dict{"secret": "s3cret"}
+	sealKwargs(kwargs)
+	sealed := kwargs["secret"]
+
+	enableTestSealing(t, []string{"secret"}, otherSealKey)
+	if _, err := unseal(sealed); err == nil || !strings.Contains(err.Error(), "wrong key") {
+		t.Errorf("expected a decryption failure, got %v", err)
+	}
+	// Values that can't be unsealed are kept as received.
+	if unsealed := unsealKwargs(kwargs); unsealed["secret"] != sealed {
+		t.Errorf("expected the sealed value to be kept, got %v", unsealed["secret"])
+	}
+}
+
+func TestUnsealInvalid(t *testing.T) {
+	enableTestSealing(t, []string{"secret"}, testSealKey)
+
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{"not a string", 42.0},
+		{"not base64", "not base64!"},
+		{"too short", "c2hvcnQ="},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := unseal(test.value); err == nil {
+				t.Errorf("expected unsealing %#v to fail", test.value)
+			}
+		})
+	}
+}
+
+func TestEnableSealingKey(t *testing.T) {
+	for _, key := range []string{"", "0001", testSealKey + "00", strings.Repeat("zz", 32)} {
+		if err := EnableSealing([]string{"secret"}, key); err == nil {
+			t.Errorf("expected key %q to be rejected", key)
+		}
+	}
+}
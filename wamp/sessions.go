@@ -0,0 +1,345 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+	"golang.org/x/term"
+)
+
+// Columns sessions can be sorted by.
+const (
+	SortByID       = "id"
+	SortByAuthid   = "authid"
+	SortByAuthrole = "authrole"
+	// SortNone lists sessions in the order of the router, as they are
+	// looked up.
+	SortNone = "none"
+)
+
+// sessionInfo is a session on the realm as returned by wamp.session.get.
+type sessionInfo struct {
+	id      wamp.ID
+	details wamp.Dict
+}
+
+func (s sessionInfo) detail(key string) string {
+	value, _ := wamp.AsString(s.details[key])
+	return value
+}
+
+// eachSession passes the sessions on the realm, except wick's own, whose
+// authrole contains authrole to each as they are looked up.
+func eachSession(session *client.Client, authrole string, each func(s sessionInfo)) error {
+	ids, err := sessionIDs(session)
+	if err != nil {
+		return err
+	}
+	metaGetEach(session, wamp.MetaProcSessionGet, ids, func(id wamp.ID, details wamp.Dict) bool {
+		s := sessionInfo{id: id, details: details}
+		if id != session.ID() && (authrole == "" || strings.Contains(s.detail("authrole"), authrole)) {
+			each(s)
+		}
+		return true
+	})
+	return nil
+}
+
+// listSessions returns the sessions on the realm, except wick's own, whose
+// authrole contains authrole, sorted by sortBy.
+func listSessions(session *client.Client, authrole string, sortBy string) ([]sessionInfo, error) {
+	var sessions []sessionInfo
+	err := eachSession(session, authrole, func(s sessionInfo) {
+		sessions = append(sessions, s)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i], sessions[j]
+		if sortBy != SortByID && sortBy != SortNone && a.detail(sortBy) != b.detail(sortBy) {
+			return a.detail(sortBy) < b.detail(sortBy)
+		}
+		return a.id < b.id
+	})
+	return sessions, nil
+}
+
+// sessionsHeader is the header of the sessions table.
+const sessionsHeader = "  SESSION\tAUTHID\tAUTHROLE\tAUTHMETHOD\tAGENT"
+
+func writeSession(output io.Writer, s sessionInfo, selected bool, newline string) {
+	marker := " "
+	if selected {
+		marker = ">"
+	}
+	fmt.Fprintf(output, "%s %d\t%s\t%s\t%s\t%s%s", marker, s.id, s.detail("authid"), s.detail("authrole"),
+		s.detail("authmethod"), s.detail("agent"), newline)
+}
+
+// writeSessions writes sessions as a table, marking the selected one.
+func writeSessions(output io.Writer, sessions []sessionInfo, selected int, newline string) {
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprint(w, sessionsHeader+newline)
+	for i, s := range sessions {
+		writeSession(w, s, i == selected, newline)
+	}
+	w.Flush()
+}
+
+// ListSessions writes the sessions on the realm whose authrole contains
+// authrole to output, sorted by sortBy. With SortNone every session is
+// written as soon as it is looked up, so the list is not held in memory;
+// the columns are separated by tabs instead of aligned then.
+func ListSessions(session *client.Client, authrole string, sortBy string, output io.Writer) {
+	if sortBy == SortNone {
+		fmt.Fprintln(output, sessionsHeader)
+		err := eachSession(session, authrole, func(s sessionInfo) {
+			writeSession(output, s, false, "\n")
+		})
+		if err != nil {
+			logger.Fatal("Failed to list sessions: ", err)
+		}
+		return
+	}
+
+	sessions, err := listSessions(session, authrole, sortBy)
+	if err != nil {
+		logger.Fatal("Failed to list sessions: ", err)
+	}
+	writeSessions(output, sessions, -1, "\n")
+}
+
+// killSession kills the session with id, giving reason to it.
+func killSession(session *client.Client, id wamp.ID) error {
+	kwargs := wamp.Dict{"reason": "wamp.close.killed", "message": "killed by wick"}
+	_, err := session.Call(context.Background(), string(wamp.MetaProcSessionKill), nil, wamp.List{id}, kwargs, nil)
+	return err
+}
+
+// sessionBrowser is the state of the interactive session list.
+type sessionBrowser struct {
+	session  *client.Client
+	authrole string
+	sortBy   string
+	sessions []sessionInfo
+	selected int
+	status   string
+	in       *bufio.Reader
+	out      io.Writer
+
+	// protected makes killing a session require typing its id.
+	protected bool
+}
+
+// browserHelp lists the keys of the session browser.
+const browserHelp = "j/k move  i inspect  K kill  s sort  f filter authrole  r refresh  q quit"
+
+// BrowseSessions shows the sessions on the realm in an interactive list
+// that can be sorted, filtered by authrole, inspected and killed. Without a
+// terminal it writes the list once, like ListSessions. If protected, killing
+// a session asks for its id rather than a yes.
+func BrowseSessions(session *client.Client, authrole string, sortBy string, protected bool) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) || !isTerminal(os.Stdout) {
+		ListSessions(session, authrole, sortBy, os.Stdout)
+		return
+	}
+
+	state, err := term.MakeRaw(fd)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	defer term.Restore(fd, state)
+
+	b := &sessionBrowser{session: session, authrole: authrole, sortBy: sortBy, in: bufio.NewReader(os.Stdin),
+		out: os.Stdout, protected: protected}
+	b.refresh()
+	for {
+		b.render()
+		key, err := b.readKey()
+		if err != nil {
+			return
+		}
+		switch key {
+		case "q", "\x03":
+			return
+		case "j", "down":
+			if b.selected < len(b.sessions)-1 {
+				b.selected++
+			}
+		case "k", "up":
+			if b.selected > 0 {
+				b.selected--
+			}
+		case "r":
+			b.refresh()
+		case "s":
+			b.sortBy = map[string]string{SortByID: SortByAuthid, SortByAuthid: SortByAuthrole,
+				SortByAuthrole: SortByID, SortNone: SortByID}[b.sortBy]
+			b.refresh()
+		case "f":
+			b.authrole = b.prompt("authrole contains: ")
+			b.refresh()
+		case "i", "\r":
+			b.inspect()
+		case "K":
+			b.kill()
+		}
+	}
+}
+
+func (b *sessionBrowser) refresh() {
+	sessions, err := listSessions(b.session, b.authrole, b.sortBy)
+	if err != nil {
+		b.status = "Failed to list sessions: " + err.Error()
+		return
+	}
+	b.sessions = sessions
+	if b.selected >= len(sessions) {
+		b.selected = len(sessions) - 1
+	}
+	if b.selected < 0 {
+		b.selected = 0
+	}
+	b.status = fmt.Sprintf("%d sessions, sorted by %s", len(sessions), b.sortBy)
+	if b.authrole != "" {
+		b.status += fmt.Sprintf(", authrole contains '%s'", b.authrole)
+	}
+}
+
+func (b *sessionBrowser) render() {
+	fmt.Fprint(b.out, clearScreen)
+	writeSessions(b.out, b.sessions, b.selected, "\r\n")
+	fmt.Fprintf(b.out, "\r\n%s\r\n%s\r\n", b.status, browserHelp)
+}
+
+func (b *sessionBrowser) current() (sessionInfo, bool) {
+	if b.selected < 0 || b.selected >= len(b.sessions) {
+		return sessionInfo{}, false
+	}
+	return b.sessions[b.selected], true
+}
+
+func (b *sessionBrowser) inspect() {
+	s, ok := b.current()
+	if !ok {
+		return
+	}
+	details, err := json.MarshalIndent(s.details, "", "    ")
+	if err != nil {
+		b.status = err.Error()
+		return
+	}
+	fmt.Fprint(b.out, clearScreen)
+	fmt.Fprintf(b.out, "%s\r\n\r\npress any key to return", strings.ReplaceAll(string(details), "\n", "\r\n"))
+	b.readKey()
+}
+
+func (b *sessionBrowser) kill() {
+	s, ok := b.current()
+	if !ok {
+		return
+	}
+	var confirmed bool
+	if b.protected {
+		answer := b.prompt(fmt.Sprintf("The realm is protected. Type %d to kill session %d (%s): ", s.id, s.id,
+			s.detail("authid")))
+		confirmed = answer == fmt.Sprint(s.id)
+	} else {
+		answer := b.prompt(fmt.Sprintf("Kill session %d (%s)? [y/N] ", s.id, s.detail("authid")))
+		confirmed = answer == "y" || answer == "yes"
+	}
+	if !confirmed {
+		b.status = "Not killed"
+		return
+	}
+	if err := killSession(b.session, s.id); err != nil {
+		b.status = fmt.Sprintf("Failed to kill session %d: %s", s.id, err)
+		return
+	}
+	b.refresh()
+	b.status = fmt.Sprintf("Killed session %d", s.id)
+}
+
+// prompt reads a line, echoing it, since the terminal is in raw mode.
+func (b *sessionBrowser) prompt(question string) string {
+	fmt.Fprintf(b.out, "\r\n%s", question)
+	var line []byte
+	for {
+		c, err := b.in.ReadByte()
+		if err != nil {
+			return ""
+		}
+		switch c {
+		case '\r', '\n':
+			return strings.TrimSpace(string(line))
+		case 0x7f, '\b':
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				fmt.Fprint(b.out, "\b \b")
+			}
+		case 0x03, 0x1b:
+			return ""
+		default:
+			line = append(line, c)
+			fmt.Fprintf(b.out, "%c", c)
+		}
+	}
+}
+
+// readKey reads a key press, translating arrow keys to "up" and "down".
+func (b *sessionBrowser) readKey() (string, error) {
+	c, err := b.in.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	if c != 0x1b || b.in.Buffered() < 2 {
+		return string(c), nil
+	}
+	seq := make([]byte, 2)
+	if _, err = io.ReadFull(b.in, seq); err != nil {
+		return "", err
+	}
+	switch string(seq) {
+	case "[A":
+		return "up", nil
+	case "[B":
+		return "down", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,348 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Snapshot is the state of a realm as seen through the meta API.
+type Snapshot struct {
+	Time          time.Time       `json:"time"`
+	Realm         string          `json:"realm"`
+	Sessions      []wamp.Dict     `json:"sessions"`
+	Registrations []SnapshotEntry `json:"registrations"`
+	Subscriptions []SnapshotEntry `json:"subscriptions"`
+}
+
+// SnapshotEntry is a registration or subscription with the sessions
+// attached to it, the callees or subscribers.
+type SnapshotEntry struct {
+	URI      string      `json:"uri"`
+	Match    string      `json:"match"`
+	Details  wamp.Dict   `json:"details"`
+	Sessions []wamp.Dict `json:"sessions"`
+}
+
+// key identifies the entry across snapshots, where ids differ.
+func (e SnapshotEntry) key() string {
+	return fmt.Sprintf("%s (%s)", e.URI, e.Match)
+}
+
+// SnapshotSummary counts what was written to a snapshot.
+type SnapshotSummary struct {
+	Sessions      int
+	Registrations int
+	Subscriptions int
+}
+
+// TakeSnapshot writes the sessions, registrations and subscriptions on the
+// realm, except wick's own, as a JSON Snapshot to output. Everything is
+// written as soon as it is looked up, so large realms are not held in
+// memory; only the authid and authrole of every session are kept, to
+// describe the callees and subscribers.
+func TakeSnapshot(session *client.Client, realm string, output io.Writer) (SnapshotSummary, error) {
+	var summary SnapshotSummary
+	w := &snapshotWriter{output: bufio.NewWriter(output)}
+	w.printf("{\n    \"time\": %s,\n    \"realm\": %s,\n", w.marshal(time.Now().UTC(), ""), w.marshal(realm, ""))
+
+	sessions := map[wamp.ID]wamp.Dict{}
+	w.startList("sessions")
+	err := eachSession(session, "", func(s sessionInfo) {
+		w.item(s.details)
+		sessions[s.id] = wamp.Dict{"session": s.id, "authid": s.details["authid"],
+			"authrole": s.details["authrole"]}
+		summary.Sessions++
+	})
+	if err != nil {
+		return summary, fmt.Errorf("failed to list sessions: %w", err)
+	}
+	w.endList(false)
+
+	w.startList("registrations")
+	summary.Registrations, err = writeSnapshotEntries(w, session, wamp.MetaProcRegList, wamp.MetaProcRegGet,
+		wamp.MetaProcRegListCallees, sessions)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list registrations: %w", err)
+	}
+	w.endList(false)
+
+	w.startList("subscriptions")
+	summary.Subscriptions, err = writeSnapshotEntries(w, session, wamp.MetaProcSubList, wamp.MetaProcSubGet,
+		wamp.MetaProcSubListSubscribers, sessions)
+	if err != nil {
+		return summary, fmt.Errorf("failed to list subscriptions: %w", err)
+	}
+	w.endList(true)
+	w.printf("}\n")
+
+	if err = w.output.Flush(); err != nil {
+		return summary, err
+	}
+	return summary, w.err
+}
+
+// writeSnapshotEntries writes every registration or subscription listed by
+// list along with the sessions attached to it and returns how many it wrote.
+func writeSnapshotEntries(w *snapshotWriter, session *client.Client, list, get, attached wamp.URI,
+	sessions map[wamp.ID]wamp.Dict) (int, error) {
+	ids, err := matchIDs(session, list)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, match := range []string{wamp.MatchExact, wamp.MatchPrefix, wamp.MatchWildcard} {
+		lookup := func(ctx context.Context, id wamp.ID) (interface{}, error) {
+			details, err := metaGet(ctx, session, get, id)
+			if err != nil {
+				return nil, err
+			}
+			uri, _ := wamp.AsString(details["uri"])
+			entry := SnapshotEntry{URI: uri, Match: match, Details: details, Sessions: []wamp.Dict{}}
+
+			result, err := session.Call(ctx, string(attached), nil, wamp.List{id}, nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", attached, err)
+			}
+			var attachedIDs wamp.List
+			if len(result.Arguments) > 0 {
+				attachedIDs, _ = wamp.AsList(result.Arguments[0])
+			}
+			for _, value := range attachedIDs {
+				sessionID, _ := wamp.AsID(value)
+				if sessionID == session.ID() {
+					continue
+				}
+				if details, ok := sessions[sessionID]; ok {
+					entry.Sessions = append(entry.Sessions, details)
+				} else {
+					// The session joined after the sessions were listed.
+					entry.Sessions = append(entry.Sessions, wamp.Dict{"session": sessionID})
+				}
+			}
+			return entry, nil
+		}
+		lookupEach(ids[match], lookup, func(id wamp.ID, result interface{}) bool {
+			w.item(result)
+			count++
+			return true
+		})
+	}
+	return count, nil
+}
+
+// snapshotWriter writes the JSON of a snapshot piece by piece, indented
+// like json.MarshalIndent would. The first error is kept in err.
+type snapshotWriter struct {
+	output *bufio.Writer
+	items  int
+	err    error
+}
+
+func (w *snapshotWriter) printf(format string, args ...interface{}) {
+	if w.err == nil {
+		_, w.err = fmt.Fprintf(w.output, format, args...)
+	}
+}
+
+func (w *snapshotWriter) marshal(value interface{}, prefix string) string {
+	data, err := json.MarshalIndent(value, prefix, "    ")
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return string(data)
+}
+
+func (w *snapshotWriter) startList(name string) {
+	w.printf("    %q: [", name)
+	w.items = 0
+}
+
+func (w *snapshotWriter) item(value interface{}) {
+	separator := ","
+	if w.items == 0 {
+		separator = ""
+	}
+	w.printf("%s\n        %s", separator, w.marshal(value, "        "))
+	w.items++
+}
+
+func (w *snapshotWriter) endList(last bool) {
+	if w.items > 0 {
+		w.printf("\n    ")
+	}
+	w.printf("]")
+	if !last {
+		w.printf(",")
+	}
+	w.printf("\n")
+}
+
+// SnapshotFileName is the default file name of a snapshot of realm taken at t.
+func SnapshotFileName(realm string, t time.Time) string {
+	return fmt.Sprintf("snapshot-%s-%s.json", realm, t.UTC().Format("20060102T150405Z"))
+}
+
+// ReadSnapshot reads a snapshot written by TakeSnapshot.
+func ReadSnapshot(path string) (*Snapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot Snapshot
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("%s is not a snapshot: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// DiffSnapshots writes what changed on the realm between snapshots a and b
+// to output and returns whether anything did. Sessions are compared by
+// authid and authrole, registrations and subscriptions by URI and match
+// policy, since ids change when clients reconnect.
+func DiffSnapshots(a, b *Snapshot, output io.Writer) bool {
+	fmt.Fprintf(output, "--- %s %s\n+++ %s %s\n", a.Realm, a.Time.Format(time.RFC3339),
+		b.Realm, b.Time.Format(time.RFC3339))
+
+	changed := diffCounts(output, "session", countSessions(a.Sessions), countSessions(b.Sessions))
+	changed = diffEntries(output, "registration", "callee", a.Registrations, b.Registrations) || changed
+	changed = diffEntries(output, "subscription", "subscriber", a.Subscriptions, b.Subscriptions) || changed
+	if !changed {
+		fmt.Fprintln(output, "no changes")
+	}
+	return changed
+}
+
+func sessionKey(details wamp.Dict) string {
+	authid, _ := wamp.AsString(details["authid"])
+	authrole, _ := wamp.AsString(details["authrole"])
+	return fmt.Sprintf("%s (%s)", authid, authrole)
+}
+
+func countSessions(sessions []wamp.Dict) map[string]int {
+	counts := map[string]int{}
+	for _, details := range sessions {
+		counts[sessionKey(details)]++
+	}
+	return counts
+}
+
+// diffCounts writes the keys only in a or b, and those whose count changed.
+func diffCounts(output io.Writer, kind string, a, b map[string]int) bool {
+	changed := false
+	for _, key := range unionKeys(a, b) {
+		before, after := a[key], b[key]
+		switch {
+		case before == after:
+			continue
+		case before == 0:
+			fmt.Fprintf(output, "+ %s %s\n", kind, key)
+		case after == 0:
+			fmt.Fprintf(output, "- %s %s\n", kind, key)
+		default:
+			fmt.Fprintf(output, "~ %s %s: %d -> %d\n", kind, key, before, after)
+		}
+		changed = true
+	}
+	return changed
+}
+
+func diffEntries(output io.Writer, kind, attached string, a, b []SnapshotEntry) bool {
+	before := map[string]SnapshotEntry{}
+	for _, entry := range a {
+		before[entry.key()] = entry
+	}
+	after := map[string]SnapshotEntry{}
+	for _, entry := range b {
+		after[entry.key()] = entry
+	}
+
+	changed := false
+	for _, key := range unionEntryKeys(before, after) {
+		old, inA := before[key]
+		entry, inB := after[key]
+		switch {
+		case !inA:
+			fmt.Fprintf(output, "+ %s %s\n", kind, key)
+		case !inB:
+			fmt.Fprintf(output, "- %s %s\n", kind, key)
+		default:
+			entryChanged := false
+			if oldInvoke, newInvoke := old.Details["invoke"], entry.Details["invoke"]; oldInvoke != newInvoke {
+				fmt.Fprintf(output, "~ %s %s: invoke %v -> %v\n", kind, key, oldInvoke, newInvoke)
+				entryChanged = true
+			}
+			entryChanged = diffCounts(output, fmt.Sprintf("%s %s %s", kind, key, attached),
+				countSessions(old.Sessions), countSessions(entry.Sessions)) || entryChanged
+			if !entryChanged {
+				continue
+			}
+		}
+		changed = true
+	}
+	return changed
+}
+
+func unionKeys(a, b map[string]int) []string {
+	seen := map[string]bool{}
+	for key := range a {
+		seen[key] = true
+	}
+	for key := range b {
+		seen[key] = true
+	}
+	return sortedKeys(seen)
+}
+
+func unionEntryKeys(a, b map[string]SnapshotEntry) []string {
+	seen := map[string]bool{}
+	for key := range a {
+		seen[key] = true
+	}
+	for key := range b {
+		seen[key] = true
+	}
+	return sortedKeys(seen)
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,136 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// uriStats holds the counters of a single topic or procedure.
+type uriStats struct {
+	events      uint64
+	invocations uint64
+	bytes       uint64
+	errors      uint64
+	lastSeen    time.Time
+}
+
+var (
+	statsMu sync.Mutex
+	stats   = map[string]*uriStats{}
+)
+
+// recordStats updates the counters of uri for one event or invocation.
+func recordStats(uri string, invocation bool, args wamp.List, kwargs wamp.Dict, failed bool) {
+	size := payloadSize(args, kwargs)
+
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	s, ok := stats[uri]
+	if !ok {
+		s = &uriStats{}
+		stats[uri] = s
+	}
+	if invocation {
+		s.invocations++
+	} else {
+		s.events++
+	}
+	s.bytes += uint64(size)
+	if failed {
+		s.errors++
+	}
+	s.lastSeen = time.Now()
+}
+
+// payloadSize returns the size of the JSON encoding of args and kwargs.
+func payloadSize(args wamp.List, kwargs wamp.Dict) int {
+	size := 0
+	if len(args) > 0 {
+		if data, err := json.Marshal(args); err == nil {
+			size += len(data)
+		}
+	}
+	if len(kwargs) > 0 {
+		if data, err := json.Marshal(kwargs); err == nil {
+			size += len(data)
+		}
+	}
+	return size
+}
+
+// printStats writes the counters of every URI seen so far to stderr.
+func printStats() {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+
+	uris := make([]string, 0, len(stats))
+	for uri := range stats {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "URI\tEVENTS\tINVOCATIONS\tBYTES\tERRORS\tLAST SEEN")
+	for _, uri := range uris {
+		s := stats[uri]
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", uri, s.events, s.invocations, s.bytes, s.errors,
+			s.lastSeen.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// watchStats prints the statistics every time the stats signal is received.
+// It does nothing on platforms without such a signal.
+func watchStats() {
+	sigChan := make(chan os.Signal, 1)
+	if !notifyStats(sigChan) {
+		return
+	}
+	go func() {
+		for range sigChan {
+			printStats()
+		}
+	}()
+}
+
+// notifyStats relays the stats signal, if the platform has one, to c.
+func notifyStats(c chan<- os.Signal) bool {
+	if statsSignal == nil {
+		return false
+	}
+	signal.Notify(c, statsSignal)
+	return true
+}
@@ -0,0 +1,137 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"encoding/json"
+	"text/template"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// forwardQueueSize is how many events may wait for a scenario handler, or
+// to be teed or to trigger a call if the subscription has no event buffer.
+const forwardQueueSize = 1024
+
+var (
+	teeTopic    string
+	teeTemplate *template.Template
+)
+
+// teeEvent is the data available to the tee template.
+type teeEvent struct {
+	Topic   string
+	Args    wamp.List
+	Kwargs  wamp.Dict
+	Details wamp.Dict
+}
+
+// EnableTee makes Subscribe republish every event it receives to topic on
+// the same realm. If tmpl is not empty, it is executed with the event's
+// .Topic, .Args, .Kwargs and .Details, and its output, typed like a command
+// line argument, is published as the only argument instead of the original
+// payload.
+func EnableTee(topic string, tmpl string) error {
+	teeTopic = topic
+	if tmpl == "" {
+		return nil
+	}
+
+	funcs := template.FuncMap{
+		"json": func(value interface{}) (string, error) {
+			data, err := json.Marshal(value)
+			return string(data), err
+		},
+	}
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+
+	var err error
+	teeTemplate, err = template.New("tee").Funcs(funcs).Parse(tmpl)
+	return err
+}
+
+// tee republishes event, received on topic, to the tee topic if enabled.
+func tee(session *client.Client, topic string, event *wamp.Event) {
+	if teeTopic == "" {
+		return
+	}
+
+	args, kwargs := event.Arguments, event.ArgumentsKw
+	if transform != nil {
+		values, err := transformPayload(args, kwargs)
+		if err != nil {
+			logger.Printf("Failed to transform event for '%s': %s\n", teeTopic, err)
+			return
+		}
+		args, kwargs = wamp.List(values), nil
+	}
+	if teeTemplate != nil {
+		var buf bytes.Buffer
+		data := teeEvent{Topic: topic, Args: args, Kwargs: kwargs, Details: event.Details}
+		if err := teeTemplate.Execute(&buf, data); err != nil {
+			logger.Printf("Failed to transform event for '%s': %s\n", teeTopic, err)
+			return
+		}
+		// The output holds data from publishers, so it must not be expanded
+		// as a template like command line arguments are.
+		typed, _ := inferValue(buf.String())
+		args, kwargs = wamp.List{typed}, nil
+	}
+
+	if _, err := publishAcknowledged(session, teeTopic, nil, args, kwargs); err != nil {
+		logger.Printf("Failed to tee event from '%s' to '%s': %s\n", topic, teeTopic, err)
+	}
+}
+
+// forwardEvents returns a function that queues events for tee and
+// eventCall. Those wait for replies from the router, which the session
+// delivers on the goroutine that runs event handlers, so they run on their
+// own goroutine, in the order the events were received. Up to bufferSize
+// events wait, or forwardQueueSize if it is 0, and onOverflow applies when
+// there are more. It must not be OverflowBlock, which would hold up the
+// replies they wait for.
+func forwardEvents(session *client.Client, bufferSize int, onOverflow string) func(topic string, event *wamp.Event) {
+	if teeTopic == "" && eventCallProcedure == "" {
+		return func(string, *wamp.Event) {}
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = forwardQueueSize
+	}
+	queue := newEventBuffer(bufferSize, onOverflow)
+	go func() {
+		for {
+			topic, event := queue.pop()
+			current := liveSession(session)
+			tee(current, topic, event)
+			eventCall(current, topic, event)
+		}
+	}()
+	return queue.push
+}
@@ -0,0 +1,110 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// seed is the seed of the random values produced by templates.
+var seed = time.Now().UnixNano()
+
+func init() {
+	rand.Seed(seed)
+}
+
+// SetSeed seeds the random values produced by templates, such as randint, so
+// a run can be reproduced exactly.
+func SetSeed(value int64) {
+	seed = value
+	rand.Seed(seed)
+}
+
+// Seed returns the seed of the random values produced by templates.
+func Seed() int64 {
+	return seed
+}
+
+// templateFuncs are the functions available in templated argument values.
+var templateFuncs = template.FuncMap{
+	"uuid":     NewCorrelationID,
+	"now_iso":  func() string { return time.Now().UTC().Format(time.RFC3339Nano) },
+	"now_unix": func() int64 { return time.Now().Unix() },
+	"randint": func(min, max int) int {
+		if max <= min {
+			return min
+		}
+		return min + rand.Intn(max-min+1)
+	},
+	"env": os.Getenv,
+	"mod": func(a, b int) int {
+		if b == 0 {
+			return 0
+		}
+		return a % b
+	},
+}
+
+// expandTemplate evaluates value as a template if it contains an action,
+// e.g. "{{uuid}}" or "{{randint 1 100}}", and returns the result.
+func expandTemplate(value string) string {
+	return expandTemplateFuncs(value, templateFuncs)
+}
+
+// expandIteration expands value like expandTemplate for the iteration-th of
+// repeated operations, which {{i}} gives, e.g. "com.api.shard{{mod i 10}}".
+func expandIteration(value string, iteration int) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+
+	funcs := template.FuncMap{"i": func() int { return iteration }}
+	for name, fn := range templateFuncs {
+		funcs[name] = fn
+	}
+	return expandTemplateFuncs(value, funcs)
+}
+
+func expandTemplateFuncs(value string, funcs template.FuncMap) string {
+	if !strings.Contains(value, "{{") {
+		return value
+	}
+
+	tmpl, err := template.New("value").Funcs(funcs).Option("missingkey=error").Parse(value)
+	if err != nil {
+		logger.Fatalf("Invalid template %q: %s", value, err)
+	}
+
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, nil); err != nil {
+		logger.Fatalf("Failed to expand template %q: %s", value, err)
+	}
+	return buf.String()
+}
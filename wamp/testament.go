@@ -0,0 +1,212 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// Testament scopes supported by wamp.session.add_testament.
+const (
+	TestamentDestroyed = "destroyed"
+	TestamentDetached  = "detached"
+)
+
+// Testament is an event the router publishes on behalf of a session when
+// that session ends.
+type Testament struct {
+	Topic   string
+	Args    []string
+	Kwargs  map[string]string
+	Options map[string]string
+	Scope   string
+}
+
+// ParseTestament parses a testament given as comma separated key=value
+// pairs, e.g. "topic=com.example.gone,args=worker-1,scope=destroyed". The
+// args key may be repeated.
+func ParseTestament(spec string) (Testament, error) {
+	testament := Testament{Scope: TestamentDestroyed}
+	for _, pair := range strings.Split(spec, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return testament, fmt.Errorf("invalid testament '%s': expected key=value pairs", spec)
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "topic":
+			testament.Topic = kv[1]
+		case "args":
+			testament.Args = append(testament.Args, kv[1])
+		case "scope":
+			testament.Scope = kv[1]
+		default:
+			return testament, fmt.Errorf("invalid testament '%s': unknown key '%s'", spec, kv[0])
+		}
+	}
+	if testament.Topic == "" {
+		return testament, fmt.Errorf("invalid testament '%s': missing topic", spec)
+	}
+	return testament, nil
+}
+
+// AddTestament asks the router to publish testament when session ends.
+func AddTestament(session *client.Client, testament Testament) error {
+	if testament.Scope != TestamentDestroyed && testament.Scope != TestamentDetached {
+		return fmt.Errorf("invalid testament scope '%s'", testament.Scope)
+	}
+
+	var options wamp.Dict
+	if len(testament.Options) > 0 {
+		options = DictToWampDict(testament.Options)
+	}
+	return addTestament(session, testament.Topic, listToWampList(testament.Args), DictToWampDict(testament.Kwargs),
+		options, testament.Scope)
+}
+
+func addTestament(session *client.Client, topic string, args wamp.List, kwargs wamp.Dict, options wamp.Dict,
+	scope string) error {
+	added := addedTestament{topic: topic, args: args, kwargs: kwargs, options: options, scope: scope}
+	if err := added.add(session); err != nil {
+		return err
+	}
+	rememberTestament(session, added)
+	return nil
+}
+
+// addedTestament is a testament added to a session, kept so a
+// ReconnectingSession can add it again to the new session.
+type addedTestament struct {
+	topic   string
+	args    wamp.List
+	kwargs  wamp.Dict
+	options wamp.Dict
+	scope   string
+}
+
+func (t addedTestament) add(session *client.Client) error {
+	callKwargs := wamp.Dict{"scope": t.scope}
+	if len(t.options) > 0 {
+		callKwargs["publish_options"] = t.options
+	}
+	callArgs := wamp.List{t.topic, t.args, t.kwargs}
+	_, err := session.Call(context.Background(), string(wamp.MetaProcSessionAddTestament), nil, callArgs,
+		callKwargs, nil)
+	if err != nil {
+		return fmt.Errorf("failed to add testament for '%s': %w", t.topic, err)
+	}
+	logger.Printf("Added %s testament for topic '%s'\n", t.scope, t.topic)
+	return nil
+}
+
+// sessionTestaments maps sessions to the testaments added to them, until the
+// session ends or is wrapped by a ReconnectingSession, which keeps them.
+var sessionTestaments = struct {
+	sync.Mutex
+	bySession map[*client.Client][]addedTestament
+}{bySession: map[*client.Client][]addedTestament{}}
+
+func rememberTestament(session *client.Client, added addedTestament) {
+	if value, ok := reconnectingSessions.Load(session); ok {
+		r := value.(*ReconnectingSession)
+		r.mu.Lock()
+		r.testaments = append(r.testaments, added)
+		r.mu.Unlock()
+		return
+	}
+
+	sessionTestaments.Lock()
+	defer sessionTestaments.Unlock()
+	sessionTestaments.bySession[session] = append(sessionTestaments.bySession[session], added)
+}
+
+// forgetTestaments drops the testaments of session in scope.
+func forgetTestaments(session *client.Client, scope string) {
+	keep := func(testaments []addedTestament) []addedTestament {
+		var kept []addedTestament
+		for _, added := range testaments {
+			if added.scope != scope {
+				kept = append(kept, added)
+			}
+		}
+		return kept
+	}
+
+	if value, ok := reconnectingSessions.Load(session); ok {
+		r := value.(*ReconnectingSession)
+		r.mu.Lock()
+		r.testaments = keep(r.testaments)
+		r.mu.Unlock()
+		return
+	}
+
+	sessionTestaments.Lock()
+	defer sessionTestaments.Unlock()
+	sessionTestaments.bySession[session] = keep(sessionTestaments.bySession[session])
+}
+
+// takeTestaments returns the testaments added to session and forgets them.
+func takeTestaments(session *client.Client) []addedTestament {
+	sessionTestaments.Lock()
+	defer sessionTestaments.Unlock()
+	testaments := sessionTestaments.bySession[session]
+	delete(sessionTestaments.bySession, session)
+	return testaments
+}
+
+// FlushTestaments removes the testaments of session in scope.
+func FlushTestaments(session *client.Client, scope string) error {
+	kwargs := wamp.Dict{"scope": scope}
+	_, err := session.Call(context.Background(), string(wamp.MetaProcSessionFlushTestaments), nil, nil, kwargs, nil)
+	if err != nil {
+		return fmt.Errorf("failed to flush testaments: %w", err)
+	}
+	forgetTestaments(session, scope)
+	logger.Printf("Flushed %s testaments\n", scope)
+	return nil
+}
+
+// HoldTestament adds testament and keeps the session open until CTRL-c or
+// the router closes it, at which point the router publishes the testament.
+func HoldTestament(session *client.Client, testament Testament) {
+	if err := AddTestament(session, testament); err != nil {
+		logger.Fatal(err)
+	}
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	select {
+	case <-sigChan:
+	case <-session.Done():
+		logger.Print("Router gone, exiting")
+	}
+}
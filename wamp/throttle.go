@@ -0,0 +1,151 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// throttleUnits are the bandwidth units accepted by SetThrottle, in bits
+// per second.
+var throttleUnits = []struct {
+	suffix string
+	bits   float64
+}{
+	{"gbps", 1e9},
+	{"mbps", 1e6},
+	{"kbps", 1e3},
+	{"bps", 1},
+}
+
+// throttleRate is the bandwidth of wick's transport in bytes per second in
+// each direction, zero for unlimited.
+var throttleRate float64
+
+// SetThrottle limits the bandwidth of the connections opened afterwards to
+// rate in each direction, e.g. "256kbps" or "1.5Mbps", to simulate
+// constrained links.
+func SetThrottle(rate string) error {
+	value := strings.ToLower(strings.TrimSpace(rate))
+	for _, unit := range throttleUnits {
+		if !strings.HasSuffix(value, unit.suffix) {
+			continue
+		}
+		number, err := strconv.ParseFloat(strings.TrimSuffix(value, unit.suffix), 64)
+		if err != nil || number <= 0 {
+			break
+		}
+		throttleRate = number * unit.bits / 8
+		return nil
+	}
+	return fmt.Errorf("invalid throttle '%s': expected a bandwidth such as 256kbps or 10Mbps", rate)
+}
+
+// dialConn connects to addr on network, throttling the connection if
+// SetThrottle was used.
+func dialConn(ctx context.Context, network string, addr string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil || throttleRate == 0 {
+		return conn, err
+	}
+	return &throttledConn{Conn: conn, read: newTokenBucket(throttleRate), write: newTokenBucket(throttleRate)}, nil
+}
+
+// throttledConn limits the rate of reads and writes of a connection.
+type throttledConn struct {
+	net.Conn
+	read  *tokenBucket
+	write *tokenBucket
+}
+
+func (c *throttledConn) Read(b []byte) (int, error) {
+	if len(b) > c.read.burst {
+		b = b[:c.read.burst]
+	}
+	n, err := c.Conn.Read(b)
+	c.read.wait(n)
+	return n, err
+}
+
+func (c *throttledConn) Write(b []byte) (int, error) {
+	var written int
+	for len(b) > 0 {
+		chunk := b
+		if len(chunk) > c.write.burst {
+			chunk = chunk[:c.write.burst]
+		}
+		c.write.wait(len(chunk))
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		b = b[n:]
+	}
+	return written, nil
+}
+
+// tokenBucket paces transfers to rate bytes per second, allowing bursts of
+// a tenth of a second worth of bytes.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64) *tokenBucket {
+	burst := int(rate / 10)
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: burst, tokens: float64(burst), last: time.Now()}
+}
+
+// wait blocks until n bytes may be transferred, with n at most burst.
+func (b *tokenBucket) wait(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > float64(b.burst) {
+		b.tokens = float64(b.burst)
+	}
+	b.last = now
+
+	b.tokens -= float64(n)
+	if b.tokens < 0 {
+		time.Sleep(time.Duration(-b.tokens / b.rate * float64(time.Second)))
+	}
+}
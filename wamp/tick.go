@@ -0,0 +1,142 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+// tickSource counts the ticks that pace repeated calls and publishes. Ticks
+// are never dropped: those that arrive faster than the operations are made
+// wait their turn.
+type tickSource struct {
+	mu      sync.Mutex
+	pending int
+	ended   bool
+	ready   chan struct{}
+}
+
+// ticks is set by EnableTickStdin and EnableTickTopic, nil otherwise.
+var ticks *tickSource
+
+func newTickSource() *tickSource {
+	return &tickSource{ready: make(chan struct{}, 1)}
+}
+
+func (t *tickSource) add() {
+	t.mu.Lock()
+	t.pending++
+	t.mu.Unlock()
+	t.notify()
+}
+
+func (t *tickSource) end() {
+	t.mu.Lock()
+	t.ended = true
+	t.mu.Unlock()
+	t.notify()
+}
+
+func (t *tickSource) notify() {
+	select {
+	case t.ready <- struct{}{}:
+	default:
+	}
+}
+
+// wait takes a tick, waiting for one if there is none. It reports whether
+// stop received, or the ticks ended, instead.
+func (t *tickSource) wait(stop <-chan os.Signal) (stopped bool, ended bool) {
+	for {
+		t.mu.Lock()
+		if t.pending > 0 {
+			t.pending--
+			t.mu.Unlock()
+			return false, false
+		}
+		if t.ended {
+			t.mu.Unlock()
+			return false, true
+		}
+		t.mu.Unlock()
+
+		select {
+		case <-stop:
+			return true, false
+		case <-t.ready:
+		}
+	}
+}
+
+// EnableTickStdin makes repeated calls and publishes wait for a line on
+// stdin before each operation, until stdin is closed.
+func EnableTickStdin() {
+	ticks = newTickSource()
+	go readTicks(os.Stdin, ticks)
+}
+
+func readTicks(reader io.Reader, source *tickSource) {
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		source.add()
+	}
+	source.end()
+}
+
+// EnableTickTopic makes repeated calls and publishes wait for an event on
+// topic before each operation, until the session ends.
+func EnableTickTopic(session *client.Client, topic string) error {
+	source := newTickSource()
+	if err := session.Subscribe(topic, func(*wamp.Event) { source.add() }, nil); err != nil {
+		return fmt.Errorf("failed to subscribe to tick topic '%s': %w", topic, err)
+	}
+	go func() {
+		<-session.Done()
+		source.end()
+	}()
+	ticks = source
+	return nil
+}
+
+// nextTick waits for the tick of the next operation, if ticks pace them. It
+// reports whether stop received, or the ticks ended, first.
+func nextTick(stop <-chan os.Signal) (stopped bool, ended bool) {
+	if ticks == nil {
+		select {
+		case <-stop:
+			return true, false
+		default:
+			return false, false
+		}
+	}
+	return ticks.wait(stop)
+}
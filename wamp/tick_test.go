@@ -0,0 +1,53 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestTickSource(t *testing.T) {
+	source := newTickSource()
+	readTicks(strings.NewReader("a\nb\n\n"), source)
+
+	stop := make(chan os.Signal, 1)
+	for i := 0; i < 3; i++ {
+		if stopped, ended := source.wait(stop); stopped || ended {
+			t.Fatalf("tick %d: got stopped %v, ended %v", i, stopped, ended)
+		}
+	}
+	if stopped, ended := source.wait(stop); stopped || !ended {
+		t.Errorf("got stopped %v, ended %v after the last line", stopped, ended)
+	}
+
+	// Waiting for a tick can be interrupted.
+	source = newTickSource()
+	stop <- os.Interrupt
+	if stopped, ended := source.wait(stop); !stopped || ended {
+		t.Errorf("got stopped %v, ended %v when interrupted", stopped, ended)
+	}
+}
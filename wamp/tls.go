@@ -0,0 +1,160 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// isSecureURL reports whether the router URL uses a TLS transport.
+func isSecureURL(routerURL string) bool {
+	u, err := url.Parse(routerURL)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "wss", "https", "rss", "tcps", "tcp4s", "tcp6s":
+		return true
+	}
+	return false
+}
+
+// tlsConfig returns the TLS configuration to connect to a secure router URL,
+// or nil for plain connections.
+func (c *ClientInfo) tlsConfig(routerURL string) *tls.Config {
+	if !isSecureURL(routerURL) {
+		return nil
+	}
+
+	tlsCfg := new(tls.Config)
+	if c.TLSConfig != nil {
+		tlsCfg = c.TLSConfig.Clone()
+	}
+	if c.ServerName != "" {
+		tlsCfg.ServerName = c.ServerName
+	}
+	if len(c.NextProtos) > 0 {
+		tlsCfg.NextProtos = c.NextProtos
+	}
+	tlsCfg.VerifyConnection = logTLSState
+
+	if len(c.PinnedSHA256) > 0 {
+		// The pin replaces verification against the system CAs, so routers
+		// with self-signed certificates can be reached without turning off
+		// verification altogether.
+		pins := c.PinnedSHA256
+		tlsCfg.InsecureSkipVerify = true
+		tlsCfg.VerifyConnection = func(state tls.ConnectionState) error {
+			if err := verifyPin(state, pins); err != nil {
+				return err
+			}
+			return logTLSState(state)
+		}
+	}
+
+	return tlsCfg
+}
+
+// LoadTLSConfig returns the TLS configuration to use with ClientInfo.TLSConfig
+// for routers with certificates of a private CA, given as a PEM bundle in
+// caFile, or that require client certificates. With insecure, the router's
+// certificate is not verified at all. It returns nil if none are given.
+func LoadTLSConfig(caFile, certFile, keyFile string, insecure bool) (*tls.Config, error) {
+	if caFile == "" && certFile == "" && keyFile == "" && !insecure {
+		return nil, nil
+	}
+
+	tlsCfg := &tls.Config{InsecureSkipVerify: insecure}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsCfg.RootCAs = x509.NewCertPool()
+		if !tlsCfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", caFile)
+		}
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.New("a client certificate needs both the certificate and its key")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsCfg, nil
+}
+
+// verifyPin checks that the SHA-256 hash of the router's certificate, or of
+// its public key (SPKI), matches one of pins.
+func verifyPin(state tls.ConnectionState, pins []string) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("router presented no certificate to verify the pin against")
+	}
+
+	cert := state.PeerCertificates[0]
+	certHash := sha256.Sum256(cert.Raw)
+	spkiHash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	certPin := base64.StdEncoding.EncodeToString(certHash[:])
+	spkiPin := base64.StdEncoding.EncodeToString(spkiHash[:])
+
+	for _, pin := range pins {
+		if pin == certPin || pin == spkiPin {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate pin mismatch: router presented certificate sha256 %s, spki sha256 %s",
+		certPin, spkiPin)
+}
+
+// logTLSState logs the negotiated parameters of a TLS connection.
+func logTLSState(state tls.ConnectionState) error {
+	version, ok := tlsVersions[state.Version]
+	if !ok {
+		version = fmt.Sprintf("0x%04x", state.Version)
+	}
+	logger.Debugf("TLS connection to %s using %s, cipher %s, ALPN %q", state.ServerName, version,
+		tls.CipherSuiteName(state.CipherSuite), state.NegotiatedProtocol)
+	return nil
+}
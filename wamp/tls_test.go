@@ -0,0 +1,101 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCertificate(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "router"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func pinOf(data []byte) string {
+	hash := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func TestVerifyPin(t *testing.T) {
+	cert := testCertificate(t)
+	other := testCertificate(t)
+	state := tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+	certHash := sha256.Sum256(cert.Raw)
+
+	tests := []struct {
+		name  string
+		state tls.ConnectionState
+		pins  []string
+		ok    bool
+	}{
+		{"certificate", state, []string{pinOf(cert.Raw)}, true},
+		{"public key", state, []string{pinOf(cert.RawSubjectPublicKeyInfo)}, true},
+		{"one of several", state, []string{pinOf(other.Raw), pinOf(cert.RawSubjectPublicKeyInfo)}, true},
+		{"other certificate", state, []string{pinOf(other.Raw), pinOf(other.RawSubjectPublicKeyInfo)}, false},
+		{"hex encoded", state, []string{hex.EncodeToString(certHash[:])}, false},
+		{"no pins", state, nil, false},
+		{"no certificate", tls.ConnectionState{}, []string{pinOf(cert.Raw)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyPin(test.state, test.pins)
+			if test.ok && err != nil {
+				t.Errorf("expected the pin to match, got %s", err)
+			}
+			if !test.ok && err == nil {
+				t.Error("expected a pin mismatch")
+			}
+		})
+	}
+}
@@ -0,0 +1,384 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+const (
+	// topTopics is how many of the busiest topics Top shows.
+	topTopics = 20
+	// topProcedures is how many of the busiest procedures Top shows.
+	topProcedures = 20
+	// topErrors is how many of the most recent errors Top shows.
+	topErrors = 5
+
+	clearScreen = "\x1b[H\x1b[2J"
+)
+
+// topStats is what Top has seen since the last refresh, and in total.
+type topStats struct {
+	mu       sync.Mutex
+	sessions int64
+	joined   int
+	left     int
+	events   map[string]int
+	totals   map[string]int
+	errors   []string
+
+	// registrations maps registration IDs to their procedure, kept up to
+	// date by the registration meta events.
+	registrations map[wamp.ID]*topRegistration
+	// calls and callTotals count the calls made by this session.
+	calls      map[string]int
+	callTotals map[string]int
+}
+
+// topRegistration is a procedure and how many callees registered it.
+type topRegistration struct {
+	procedure string
+	callees   int
+}
+
+func newTopStats() *topStats {
+	return &topStats{
+		events:        map[string]int{},
+		totals:        map[string]int{},
+		registrations: map[wamp.ID]*topRegistration{},
+		calls:         map[string]int{},
+		callTotals:    map[string]int{},
+	}
+}
+
+// call calls procedure on session, counting it as own traffic and recording
+// a failure as an error.
+func (s *topStats) call(session *client.Client, procedure wamp.URI, args wamp.List) (*wamp.Result, error) {
+	s.mu.Lock()
+	s.calls[string(procedure)]++
+	s.callTotals[string(procedure)]++
+	s.mu.Unlock()
+
+	result, err := session.Call(context.Background(), string(procedure), nil, args, nil, nil)
+	if err != nil {
+		s.addError("%s: %s", procedure, err)
+	}
+	return result, err
+}
+
+// onRegistration handles the registration meta events. It runs on the
+// receive goroutine of the session, so it must not make calls.
+func (s *topStats) onRegistration(uri wamp.URI, event *wamp.Event) {
+	if len(event.Arguments) < 2 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if uri == wamp.MetaEventRegOnCreate {
+		details, _ := wamp.AsDict(event.Arguments[1])
+		id, ok := wamp.AsID(details["id"])
+		procedure, _ := wamp.AsString(details["uri"])
+		if ok {
+			s.registrations[id] = &topRegistration{procedure: procedure}
+		}
+		return
+	}
+
+	id, ok := wamp.AsID(event.Arguments[1])
+	if !ok {
+		return
+	}
+	registration, ok := s.registrations[id]
+	if !ok {
+		return
+	}
+	switch uri {
+	case wamp.MetaEventRegOnRegister:
+		registration.callees++
+	case wamp.MetaEventRegOnUnregister:
+		if registration.callees > 0 {
+			registration.callees--
+		}
+	case wamp.MetaEventRegOnDelete:
+		delete(s.registrations, id)
+	}
+}
+
+// loadRegistrations fills the registrations that existed before Top
+// subscribed to the registration meta events.
+func (s *topStats) loadRegistrations(session *client.Client) {
+	result, err := s.call(session, wamp.MetaProcRegList, nil)
+	if err != nil || len(result.Arguments) == 0 {
+		return
+	}
+	lists, _ := wamp.AsDict(result.Arguments[0])
+	for _, ids := range lists {
+		list, _ := wamp.AsList(ids)
+		for _, value := range list {
+			id, ok := wamp.AsID(value)
+			if !ok {
+				continue
+			}
+			result, err = s.call(session, wamp.MetaProcRegGet, wamp.List{id})
+			if err != nil || len(result.Arguments) == 0 {
+				continue
+			}
+			details, _ := wamp.AsDict(result.Arguments[0])
+			procedure, _ := wamp.AsString(details["uri"])
+			registration := &topRegistration{procedure: procedure}
+			if result, err = s.call(session, wamp.MetaProcRegCountCallees, wamp.List{id}); err == nil &&
+				len(result.Arguments) > 0 {
+				callees, _ := wamp.AsInt64(result.Arguments[0])
+				registration.callees = int(callees)
+			}
+
+			s.mu.Lock()
+			if _, ok = s.registrations[id]; !ok {
+				s.registrations[id] = registration
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *topStats) addError(format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	message := time.Now().Format("15:04:05 ") + fmt.Sprintf(format, args...)
+	s.errors = append(s.errors, message)
+	if len(s.errors) > topErrors {
+		s.errors = s.errors[len(s.errors)-topErrors:]
+	}
+}
+
+// Top shows live event rates per topic under prefixes, session counts and
+// the number of registrations and subscriptions on the realm, refreshing
+// every interval until CTRL-c. On a terminal the screen is redrawn, else
+// snapshots are written one after the other. Procedures are listed from the
+// registration meta events with their callees; as calls between other
+// sessions are not visible, call rates only count the calls of this session.
+// prefixes must not be empty, as routers reject an empty prefix subscription.
+func Top(session *client.Client, prefixes []string, interval time.Duration, output io.Writer) {
+	stats := newTopStats()
+
+	if result, err := stats.call(session, wamp.MetaProcSessionCount, nil); err == nil && len(result.Arguments) > 0 {
+		stats.sessions, _ = wamp.AsInt64(result.Arguments[0])
+	}
+
+	onSession := func(delta int) client.EventHandler {
+		return func(*wamp.Event) {
+			stats.mu.Lock()
+			defer stats.mu.Unlock()
+			stats.sessions += int64(delta)
+			if delta > 0 {
+				stats.joined++
+			} else {
+				stats.left++
+			}
+		}
+	}
+	for uri, delta := range map[wamp.URI]int{wamp.MetaEventSessionOnJoin: 1, wamp.MetaEventSessionOnLeave: -1} {
+		if err := session.Subscribe(string(uri), onSession(delta), nil); err != nil {
+			stats.addError("subscribe to %s: %s", uri, err)
+		}
+	}
+	for _, uri := range []wamp.URI{wamp.MetaEventRegOnCreate, wamp.MetaEventRegOnRegister,
+		wamp.MetaEventRegOnUnregister, wamp.MetaEventRegOnDelete} {
+		metaEvent := uri
+		if err := session.Subscribe(string(uri), func(event *wamp.Event) {
+			stats.onRegistration(metaEvent, event)
+		}, nil); err != nil {
+			stats.addError("subscribe to %s: %s", uri, err)
+		}
+	}
+	stats.loadRegistrations(session)
+
+	options := wamp.Dict{wamp.OptMatch: wamp.MatchPrefix}
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			logger.Fatal("Top needs a non-empty topic prefix")
+		}
+		subscribedPrefix := prefix
+		err := session.Subscribe(prefix, func(event *wamp.Event) {
+			topic, ok := wamp.AsString(event.Details["topic"])
+			if !ok {
+				topic = subscribedPrefix
+			}
+			stats.mu.Lock()
+			stats.events[topic]++
+			stats.totals[topic]++
+			stats.mu.Unlock()
+		}, options)
+		if err != nil {
+			logger.Fatalf("Failed to subscribe to prefix '%s': %s", prefix, err)
+		}
+	}
+
+	terminal := false
+	if file, ok := output.(*os.File); ok {
+		terminal = isTerminal(file)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt)
+	for {
+		select {
+		case <-ticker.C:
+			registrations := countMeta(session, wamp.MetaProcRegList, stats)
+			subscriptions := countMeta(session, wamp.MetaProcSubList, stats)
+			if terminal {
+				fmt.Fprint(output, clearScreen)
+			}
+			stats.render(output, interval, registrations, subscriptions)
+		case <-sigChan:
+			return
+		case <-session.Done():
+			logger.Print("Router gone, exiting")
+			return
+		}
+	}
+}
+
+// countMeta returns the number of registrations or subscriptions listed by
+// the meta procedure, or -1 if it failed.
+func countMeta(session *client.Client, procedure wamp.URI, stats *topStats) int {
+	result, err := stats.call(session, procedure, nil)
+	if err != nil {
+		return -1
+	}
+	if len(result.Arguments) == 0 {
+		return 0
+	}
+	lists, _ := wamp.AsDict(result.Arguments[0])
+	count := 0
+	for _, ids := range lists {
+		list, _ := wamp.AsList(ids)
+		count += len(list)
+	}
+	return count
+}
+
+// render writes the stats of the last interval to output and resets them.
+func (s *topStats) render(output io.Writer, interval time.Duration, registrations, subscriptions int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	formatCount := func(count int) string {
+		if count < 0 {
+			return "?"
+		}
+		return fmt.Sprint(count)
+	}
+	fmt.Fprintf(output, "%s  sessions: %d (+%d -%d)  registrations: %s  subscriptions: %s\n\n",
+		time.Now().Format("15:04:05"), s.sessions, s.joined, s.left, formatCount(registrations),
+		formatCount(subscriptions))
+
+	topics := make([]string, 0, len(s.totals))
+	for topic := range s.totals {
+		topics = append(topics, topic)
+	}
+	sort.Slice(topics, func(i, j int) bool {
+		if s.events[topics[i]] != s.events[topics[j]] {
+			return s.events[topics[i]] > s.events[topics[j]]
+		}
+		return topics[i] < topics[j]
+	})
+	if len(topics) > topTopics {
+		topics = topics[:topTopics]
+	}
+
+	w := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tEVENTS/S\tTOTAL")
+	for _, topic := range topics {
+		rate := float64(s.events[topic]) / interval.Seconds()
+		fmt.Fprintf(w, "%s\t%.1f\t%d\n", topic, rate, s.totals[topic])
+	}
+	w.Flush()
+
+	// The meta procedures of the router are only shown when called.
+	callees := map[string]int{}
+	for _, registration := range s.registrations {
+		if !strings.HasPrefix(registration.procedure, "wamp.") {
+			callees[registration.procedure] += registration.callees
+		}
+	}
+	procedures := make([]string, 0, len(callees)+len(s.callTotals))
+	for procedure := range callees {
+		procedures = append(procedures, procedure)
+	}
+	for procedure := range s.callTotals {
+		if _, ok := callees[procedure]; !ok {
+			procedures = append(procedures, procedure)
+		}
+	}
+	sort.Slice(procedures, func(i, j int) bool {
+		if s.calls[procedures[i]] != s.calls[procedures[j]] {
+			return s.calls[procedures[i]] > s.calls[procedures[j]]
+		}
+		return procedures[i] < procedures[j]
+	})
+	if len(procedures) > topProcedures {
+		procedures = procedures[:topProcedures]
+	}
+
+	fmt.Fprintln(output)
+	w = tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PROCEDURE\tCALLEES\tCALLS/S\tTOTAL")
+	for _, procedure := range procedures {
+		count, ok := callees[procedure]
+		registered := "-"
+		if ok {
+			registered = fmt.Sprint(count)
+		}
+		rate := float64(s.calls[procedure]) / interval.Seconds()
+		fmt.Fprintf(w, "%s\t%s\t%.1f\t%d\n", procedure, registered, rate, s.callTotals[procedure])
+	}
+	w.Flush()
+
+	if len(s.errors) > 0 {
+		fmt.Fprintf(output, "\nRECENT ERRORS\n%s\n", strings.Join(s.errors, "\n"))
+	}
+	fmt.Fprintln(output)
+
+	s.events = map[string]int{}
+	s.calls = map[string]int{}
+	s.joined, s.left = 0, 0
+}
@@ -0,0 +1,69 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gammazero/nexus/v3/wamp"
+)
+
+func TestTopRegistrations(t *testing.T) {
+	stats := newTopStats()
+	stats.onRegistration(wamp.MetaEventRegOnCreate, &wamp.Event{
+		Arguments: wamp.List{1, wamp.Dict{"id": wamp.ID(7), "uri": "com.example.add"}}})
+	stats.onRegistration(wamp.MetaEventRegOnRegister, &wamp.Event{Arguments: wamp.List{1, wamp.ID(7)}})
+	stats.onRegistration(wamp.MetaEventRegOnRegister, &wamp.Event{Arguments: wamp.List{2, wamp.ID(7)}})
+	stats.onRegistration(wamp.MetaEventRegOnUnregister, &wamp.Event{Arguments: wamp.List{1, wamp.ID(7)}})
+	// Events for unknown registrations and malformed events are ignored.
+	stats.onRegistration(wamp.MetaEventRegOnRegister, &wamp.Event{Arguments: wamp.List{1, wamp.ID(8)}})
+	stats.onRegistration(wamp.MetaEventRegOnRegister, &wamp.Event{Arguments: wamp.List{1}})
+
+	if registration := stats.registrations[7]; registration == nil || registration.procedure != "com.example.add" ||
+		registration.callees != 1 {
+		t.Fatalf("unexpected registration %+v", registration)
+	}
+
+	stats.calls["wamp.session.count"] = 2
+	stats.callTotals["wamp.session.count"] = 5
+	var output bytes.Buffer
+	stats.render(&output, time.Second, 1, 0)
+	for _, row := range []string{`com\.example\.add\s+1\s+0\.0\s+0`, `wamp\.session\.count\s+-\s+2\.0\s+5`} {
+		if !regexp.MustCompile(row).Match(output.Bytes()) {
+			t.Errorf("expected a row matching %q in\n%s", row, output.String())
+		}
+	}
+	if len(stats.calls) != 0 {
+		t.Errorf("expected the interval calls to be reset, got %v", stats.calls)
+	}
+
+	stats.onRegistration(wamp.MetaEventRegOnDelete, &wamp.Event{Arguments: wamp.List{2, wamp.ID(7)}})
+	if len(stats.registrations) != 0 {
+		t.Errorf("expected the registration to be deleted, got %v", stats.registrations)
+	}
+}
@@ -0,0 +1,105 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/itchyny/gojq"
+)
+
+var transform *gojq.Code
+
+// SetTransform applies the jq expression to payloads before they are printed
+// or teed. The expression receives {"args": [...], "kwargs": {...}} and
+// every value it produces is handled in place of the payload.
+func SetTransform(expression string) error {
+	query, err := gojq.Parse(expression)
+	if err != nil {
+		return err
+	}
+	code, err := gojq.Compile(query, gojq.WithFunction("uuid", 0, 0,
+		func(interface{}, []interface{}) interface{} { return NewCorrelationID() }))
+	if err != nil {
+		return err
+	}
+	transform = code
+	return nil
+}
+
+// transformPayload runs the transform over args and kwargs and returns the
+// values it produced.
+func transformPayload(args wamp.List, kwargs wamp.Dict) ([]interface{}, error) {
+	if args == nil {
+		args = wamp.List{}
+	}
+	if kwargs == nil {
+		kwargs = wamp.Dict{}
+	}
+
+	// gojq only handles plain JSON types, so normalize the payload first.
+	data, err := json.Marshal(map[string]interface{}{"args": args, "kwargs": kwargs})
+	if err != nil {
+		return nil, err
+	}
+	var input interface{}
+	if err = json.Unmarshal(data, &input); err != nil {
+		return nil, err
+	}
+
+	var values []interface{}
+	iter := transform.Run(input)
+	for {
+		value, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := value.(error); ok {
+			return nil, fmt.Errorf("transform: %v", err)
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// printTransformed prints the values the transform produces for args and
+// kwargs, and reports whether a transform is set.
+func printTransformed(args wamp.List, kwargs wamp.Dict) bool {
+	if transform == nil {
+		return false
+	}
+
+	values, err := transformPayload(args, kwargs)
+	if err != nil {
+		logger.Println(err)
+		return true
+	}
+	for _, value := range values {
+		printJSON(value)
+	}
+	return true
+}
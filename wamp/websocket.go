@@ -0,0 +1,130 @@
+/*
+*
+* Copyright 2021-2022 Simple Things Inc.
+*
+* Permission is hereby granted, free of charge, to any person obtaining a copy
+* of this software and associated documentation files (the "Software"), to deal
+* in the Software without restriction, including without limitation the rights
+* to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+* copies of the Software, and to permit persons to whom the Software is
+* furnished to do so, subject to the following conditions:
+*
+* The above copyright notice and this permission notice shall be included in all
+* copies or substantial portions of the Software.
+*
+* THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+* IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+* FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+* AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+* LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+* OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE
+* SOFTWARE.
+*
+ */
+
+package wamp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"github.com/gammazero/nexus/v3/client"
+	"github.com/gammazero/nexus/v3/transport"
+	"github.com/gammazero/nexus/v3/transport/serialize"
+	"github.com/gammazero/nexus/v3/wamp"
+	"github.com/gorilla/websocket"
+)
+
+// ParseHeaders parses "Name: value" headers to send in the websocket
+// handshake. A name may be given more than once.
+func ParseHeaders(headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, header := range headers {
+		colon := strings.Index(header, ":")
+		if colon < 0 {
+			return nil, fmt.Errorf("invalid header '%s': expected 'Name: value'", header)
+		}
+		name := strings.TrimSpace(header[:colon])
+		if name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid header name in '%s'", header)
+		}
+		parsed.Add(textproto.CanonicalMIMEHeaderKey(name), strings.TrimSpace(header[colon+1:]))
+	}
+	return parsed, nil
+}
+
+// ValidateProxy checks that the websocket transport can connect through the
+// proxy at proxy, an http:// or socks5:// URL.
+func ValidateProxy(proxy string) error {
+	_, err := parseProxy(proxy)
+	return err
+}
+
+func parseProxy(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy '%s': %w", proxy, err)
+	}
+	switch proxyURL.Scheme {
+	case "http", "socks5":
+	case "socks5h":
+		// The SOCKS5 dialer always leaves resolving the router's hostname to
+		// the proxy, which is what socks5h asks for.
+		proxyURL.Scheme = "socks5"
+	default:
+		return nil, fmt.Errorf("invalid proxy '%s': expected an http:// or socks5:// URL", proxy)
+	}
+	if proxyURL.Host == "" {
+		return nil, fmt.Errorf("invalid proxy '%s': missing host", proxy)
+	}
+	return proxyURL, nil
+}
+
+// dialWebsocket connects to the router at routerURL over websocket, like
+// transport.ConnectWebsocketPeer does, but sends header in the handshake.
+func dialWebsocket(ctx context.Context, routerURL string, cfg *client.Config, header http.Header) (wamp.Peer,
+	error) {
+	var protocol string
+	var payloadType int
+	var serializer serialize.Serializer
+	switch cfg.Serialization {
+	case serialize.JSON:
+		protocol, payloadType, serializer = "wamp.2.json", websocket.TextMessage, &serialize.JSONSerializer{}
+	case serialize.MSGPACK:
+		protocol, payloadType, serializer = "wamp.2.msgpack", websocket.BinaryMessage,
+			&serialize.MessagePackSerializer{}
+	case serialize.CBOR:
+		protocol, payloadType, serializer = "wamp.2.cbor", websocket.BinaryMessage, &serialize.CBORSerializer{}
+	default:
+		return nil, fmt.Errorf("unsupported serialization: %v", cfg.Serialization)
+	}
+
+	dialer := websocket.Dialer{
+		Subprotocols:    []string{protocol},
+		TLSClientConfig: cfg.TlsCfg,
+		Proxy:           http.ProxyFromEnvironment,
+		NetDialContext: func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			return dialConn(ctx, network, addr)
+		},
+		Jar:               cfg.WsCfg.Jar,
+		EnableCompression: cfg.WsCfg.EnableCompression,
+	}
+	if cfg.WsCfg.ProxyURL != "" {
+		proxyURL, err := parseProxy(cfg.WsCfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		dialer.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	conn, response, err := dialer.DialContext(ctx, routerURL, header)
+	if err != nil {
+		return nil, &transport.WebsocketError{Err: err, Response: response}
+	}
+	return transport.NewWebsocketPeer(conn, serializer, payloadType, cfg.Logger, cfg.WsCfg.KeepAlive, 0), nil
+}